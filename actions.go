@@ -0,0 +1,300 @@
+package ftpserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+
+	"github.com/oarkflow/ftp-server/fs"
+	"github.com/oarkflow/ftp-server/models"
+)
+
+const (
+	// EventActionCompleted ... Emitted when a post-processing action succeeded.
+	EventActionCompleted = "ActionCompleted"
+	// EventActionFailed ... Emitted when a post-processing action failed.
+	EventActionFailed = "ActionFailed"
+)
+
+// Action ... A post-processing step run after a file matching Pattern has been uploaded
+// successfully. Exactly one of Command, MoveTo or Hook is expected to be set. Actions run in
+// the background once the client has closed the file, in the order they were configured, and
+// report their outcome as ActionCompleted or ActionFailed notifications.
+type Action struct {
+	Name string `json:"name"`
+	// Pattern is a path.Match pattern checked against the uploaded path, e.g. "/inbox/*.csv".
+	Pattern string `json:"pattern"`
+	// Command runs a local command. It is executed directly, with the arguments as its argv,
+	// never through a shell. An argument made of one of the placeholders {path},
+	// {local_path}, {name} or {user} is replaced by its value, if none of them is used the
+	// path is appended instead. Values starting with a dash are prefixed with "./" so that
+	// they are not taken as options. Placeholders cannot be part of a longer argument: paths
+	// are chosen by clients, and would end up in the code of commands such as "sh -c".
+	// Such commands read the SFTP_PATH, SFTP_LOCAL_PATH, SFTP_NAME and SFTP_USER
+	// environment variables instead. {local_path} is only available for backends storing
+	// files on the local disk.
+	Command []string `json:"command"`
+	// MoveTo moves the file into this directory, on the same filesystem or on Filesystem
+	// when it is set.
+	MoveTo     string             `json:"move_to"`
+	Filesystem *models.Filesystem `json:"filesystem"`
+	// Hook is called with the details of the upload.
+	Hook func(ctx context.Context, file ActionFile) error `json:"-"`
+	// Timeout bounds the action, it defaults to five minutes.
+	Timeout time.Duration `json:"timeout"`
+}
+
+// ErrEmbeddedPlaceholder ... Returned for a command using a placeholder as part of a longer
+// argument, through which clients could inject code or options in the names of their files.
+var ErrEmbeddedPlaceholder = errors.New("placeholders must make up a whole argument, use the SFTP_* environment variables instead")
+
+var placeholders = []string{"{path}", "{local_path}", "{name}", "{user}"}
+
+// ActionFile ... The uploaded file an action is run for.
+type ActionFile struct {
+	Notification Notification
+	// FS is the filesystem the file currently lives on, and Path its location on it. Both
+	// reflect any move made by a previous action.
+	FS   fs.FS
+	Path string
+}
+
+// LocalPather ... Implemented by backends that store files on the local disk, returning the
+// location on disk of a client path.
+type LocalPather interface {
+	LocalPath(p string) (string, error)
+}
+
+// Matches reports whether the action applies to the uploaded path.
+func (a Action) Matches(p string) bool {
+	matched, err := path.Match(a.Pattern, p)
+	return err == nil && matched
+}
+
+// CheckCommand returns ErrEmbeddedPlaceholder when a placeholder is part of a longer argument
+// of the command.
+func (a Action) CheckCommand() error {
+	for _, arg := range a.Command {
+		if slices.Contains(placeholders, arg) {
+			continue
+		}
+		if slices.ContainsFunc(placeholders, func(p string) bool { return strings.Contains(arg, p) }) {
+			return ErrEmbeddedPlaceholder
+		}
+	}
+	return nil
+}
+
+func (a Action) name() string {
+	if a.Name != "" {
+		return a.Name
+	}
+	switch {
+	case len(a.Command) > 0:
+		return "command"
+	case a.MoveTo != "":
+		return "move"
+	case a.Hook != nil:
+		return "hook"
+	}
+	return "action"
+}
+
+// runActions runs the actions matching a completed upload, one after the other.
+func (c *Server) runActions(fst fs.FS, n Notification) {
+	file := ActionFile{Notification: n, FS: fst, Path: n.Subject}
+	for _, action := range c.actions {
+		if !action.Matches(n.Subject) {
+			continue
+		}
+		timeout := action.Timeout
+		if timeout <= 0 {
+			timeout = 5 * time.Minute
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		moved, err := c.runAction(ctx, action, file)
+		cancel()
+
+		result := n
+		result.Time = time.Now().UTC()
+		result.Action = action.name()
+		result.Subject = file.Path
+		result.Target = ""
+		result.Error = err
+		if moved.Path != file.Path {
+			result.Target = moved.Path
+		}
+		if err != nil {
+			result.Event = EventActionFailed
+			c.logger.Error("post-processing action failed", "action", result.Action, "subject", file.Path, "err", err)
+		} else {
+			result.Event = EventActionCompleted
+			c.logger.Info("post-processing action completed", "action", result.Action, "subject", file.Path)
+		}
+		c.publish(result)
+		if err != nil {
+			// Later actions may depend on this one, so do not carry on.
+			return
+		}
+		file = moved
+	}
+}
+
+func (c *Server) runAction(ctx context.Context, action Action, file ActionFile) (ActionFile, error) {
+	switch {
+	case len(action.Command) > 0:
+		if err := action.CheckCommand(); err != nil {
+			return file, err
+		}
+		return file, runCommand(ctx, action.Command, file)
+	case action.MoveTo != "":
+		return c.moveFile(action, file)
+	case action.Hook != nil:
+		return file, action.Hook(ctx, file)
+	}
+	return file, errors.New("action has nothing to run")
+}
+
+func runCommand(ctx context.Context, command []string, file ActionFile) error {
+	var localPath string
+	if lp, ok := file.FS.(LocalPather); ok {
+		localPath, _ = lp.LocalPath(file.Path)
+	}
+	values := map[string]string{
+		"{path}":       file.Path,
+		"{local_path}": localPath,
+		"{name}":       path.Base(file.Path),
+		"{user}":       file.Notification.User,
+	}
+	appended := file.Path
+	if localPath != "" {
+		appended = localPath
+	}
+	args := commandArgs(command[1:], values, appended)
+
+	cmd := exec.CommandContext(ctx, command[0], args...)
+	cmd.Env = append(os.Environ(),
+		"SFTP_USER="+file.Notification.User,
+		"SFTP_PATH="+file.Path,
+		"SFTP_LOCAL_PATH="+localPath,
+		"SFTP_NAME="+path.Base(file.Path),
+		"SFTP_FS_TYPE="+file.FS.Type(),
+		"SFTP_CHECKSUM="+file.Notification.Checksum,
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// commandArgs replaces the arguments made of a placeholder by its value, or appends appended
+// when there are none.
+func commandArgs(args []string, values map[string]string, appended string) []string {
+	out := make([]string, 0, len(args)+1)
+	expanded := false
+	for _, arg := range args {
+		if val, ok := values[arg]; ok {
+			arg, expanded = argument(val), true
+		}
+		out = append(out, arg)
+	}
+	if !expanded {
+		out = append(out, argument(appended))
+	}
+	return out
+}
+
+// argument keeps a value chosen by a client from being taken as an option.
+func argument(val string) string {
+	if strings.HasPrefix(val, "-") {
+		return "./" + val
+	}
+	return val
+}
+
+// moveFile moves the file into the directory of the action, renaming it in place when it
+// stays on the same filesystem and copying it across otherwise.
+func (c *Server) moveFile(action Action, file ActionFile) (ActionFile, error) {
+	target := path.Join("/", action.MoveTo, path.Base(file.Path))
+	if action.Filesystem == nil {
+		if err := fsCmd(file.FS, "Mkdir", path.Dir(target), ""); err != nil {
+			return file, err
+		}
+		if err := fsCmd(file.FS, "Rename", file.Path, target); err != nil {
+			return file, err
+		}
+		return ActionFile{Notification: file.Notification, FS: file.FS, Path: target}, nil
+	}
+
+	dst, err := c.newFilesystem(*action.Filesystem, c.basePath, file.Notification.User)
+	if err != nil {
+		return file, err
+	}
+	dst.SetLogger(c.logger)
+	dst.SetContext(file.FS.Context())
+	if err := copyFile(file.FS, file.Path, dst, target); err != nil {
+		return file, err
+	}
+	if err := fsCmd(file.FS, "Remove", file.Path, ""); err != nil {
+		return file, err
+	}
+	return ActionFile{Notification: file.Notification, FS: dst, Path: target}, nil
+}
+
+// fsCmd runs a file command against the filesystem the way the SFTP server would.
+func fsCmd(fst fs.FS, method, p, target string) error {
+	request := sftp.NewRequest(method, p)
+	request.Target = target
+	if err := fst.Filecmd(request); err != nil && !errors.Is(err, sftp.ErrSshFxOk) {
+		return fmt.Errorf("%s %s: %w", strings.ToLower(method), p, err)
+	}
+	return nil
+}
+
+// copyFile streams a file from one filesystem to another.
+func copyFile(src fs.FS, srcPath string, dst fs.FS, dstPath string) (err error) {
+	lister, err := src.Filelist(sftp.NewRequest("Stat", srcPath))
+	if err != nil {
+		return err
+	}
+	infos := make([]os.FileInfo, 1)
+	if n, _ := lister.ListAt(infos, 0); n == 0 {
+		return os.ErrNotExist
+	}
+
+	r, err := src.Fileread(sftp.NewRequest("Get", srcPath))
+	if err != nil {
+		return err
+	}
+	if c, ok := r.(io.Closer); ok {
+		defer c.Close()
+	}
+	w, err := dst.Filewrite(sftp.NewRequest("Put", dstPath))
+	if err != nil {
+		return err
+	}
+	if c, ok := w.(io.Closer); ok {
+		defer func() {
+			if cerr := c.Close(); err == nil {
+				err = cerr
+			}
+		}()
+	}
+	_, err = io.Copy(io.NewOffsetWriter(w, 0), io.NewSectionReader(r, 0, infos[0].Size()))
+	if err != nil {
+		if te, ok := w.(sftp.TransferError); ok {
+			te.TransferError(err)
+		}
+	}
+	return err
+}
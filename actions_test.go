@@ -0,0 +1,105 @@
+package ftpserver
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/oarkflow/ftp-server/fs/afos"
+)
+
+func TestCheckCommand(t *testing.T) {
+	tests := []struct {
+		command []string
+		err     error
+	}{
+		{command: []string{"gzip", "{local_path}"}},
+		{command: []string{"cp", "--", "{path}", "{name}", "{user}"}},
+		{command: []string{"sh", "-c", `gzip "$SFTP_LOCAL_PATH"`}},
+		{command: []string{"sh", "-c", "cat {path}"}, err: ErrEmbeddedPlaceholder},
+		{command: []string{"env", "sh", "-c", "cat {path}"}, err: ErrEmbeddedPlaceholder},
+		{command: []string{"python3", "-c", "open('{local_path}')"}, err: ErrEmbeddedPlaceholder},
+		{command: []string{"convert", "--output={name}.png"}, err: ErrEmbeddedPlaceholder},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.command, " "), func(t *testing.T) {
+			if err := (Action{Command: tt.command}).CheckCommand(); !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCommandArgs(t *testing.T) {
+	values := map[string]string{
+		"{path}": "/in/--output=x",
+		"{name}": "--output=x",
+		"{user}": "alice",
+	}
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{args: []string{"{path}"}, want: []string{"/in/--output=x"}},
+		{args: []string{"-v", "{name}", "{user}"}, want: []string{"-v", "./--output=x", "alice"}},
+		{args: []string{"-v"}, want: []string{"-v", "./-appended"}},
+		{args: nil, want: []string{"./-appended"}},
+	}
+	for _, tt := range tests {
+		got := commandArgs(tt.args, values, "-appended")
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%q expands to %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+// TestRunCommandInjection runs commands for an upload whose name is shell code and an option.
+func TestRunCommandInjection(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell to run the commands with")
+	}
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "data"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out")
+	pwned := out + ".pwned"
+	t.Setenv("OUT", out)
+	name := `-n $(touch "$OUT.pwned")`
+	file := ActionFile{FS: afos.New(dir), Path: "/" + name}
+
+	tests := []struct {
+		command []string
+		want    string
+	}{
+		// The name is a whole argument, passed to the script as $1.
+		{command: []string{"sh", "-c", `printf '%s' "$1" > "$OUT"`, "sh", "{name}"}, want: "./" + name},
+		{command: []string{"sh", "-c", `printf '%s' "$SFTP_NAME" > "$OUT"`}, want: name},
+	}
+	for _, tt := range tests {
+		if err := runCommand(context.Background(), tt.command, file); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(out)
+		if err != nil || string(data) != tt.want {
+			t.Errorf("%q got %q, %v, want %q", tt.command, data, err, tt.want)
+		}
+	}
+	if _, err := os.Stat(pwned); err == nil {
+		t.Fatal("the name of the upload was run as shell code")
+	}
+
+	// Commands that embed placeholders are refused when run, not only when validated.
+	action := Action{Command: []string{"sh", "-c", `printf '%s' {name} > "$OUT"`}}
+	if _, err := (&Server{}).runAction(context.Background(), action, file); !errors.Is(err, ErrEmbeddedPlaceholder) {
+		t.Fatalf("got %v, want %v", err, ErrEmbeddedPlaceholder)
+	}
+	if _, err := os.Stat(pwned); err == nil {
+		t.Fatal("the name of the upload was run as shell code")
+	}
+}
@@ -0,0 +1,308 @@
+// Package audit writes an append-only trail of every operation performed on the server as
+// JSON lines, with size based rotation and optional hash chaining of the records.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Record ... A single audited operation.
+type Record struct {
+	Time      time.Time `json:"time"`
+	SessionID string    `json:"session_id,omitempty"`
+	User      string    `json:"user,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	FsType    string    `json:"fs_type,omitempty"`
+	Operation string    `json:"operation"`
+	Path      string    `json:"path,omitempty"`
+	Target    string    `json:"target,omitempty"`
+	// Code is the SFTP status code the operation resulted in, and Result its name.
+	Code     uint32  `json:"code"`
+	Result   string  `json:"result"`
+	Error    string  `json:"error,omitempty"`
+	Bytes    int64   `json:"bytes,omitempty"`
+	Duration float64 `json:"duration_ms"`
+	// PrevHash and Hash chain the records together when hash chaining is enabled. Hash is
+	// the SHA-256 of PrevHash followed by the record encoded without its Hash.
+	PrevHash string `json:"prev_hash,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+// Config ... Configures an audit log.
+type Config struct {
+	// Path of the file records are appended to.
+	Path string `json:"path"`
+	// MaxSize is the size in bytes after which the file is rotated, 0 disables rotation.
+	MaxSize int64 `json:"max_size"`
+	// MaxBackups is the number of rotated files kept, 0 keeps all of them.
+	MaxBackups int `json:"max_backups"`
+	// HashChain links every record to the previous one so that removing or altering a
+	// record can be detected with Verify.
+	HashChain bool `json:"hash_chain"`
+}
+
+// Log ... An audit log file.
+type Log struct {
+	config   Config
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	lastHash string
+}
+
+// Open opens the audit log for appending, creating it if needed. When hash chaining is enabled
+// the chain continues from the last record already written.
+func Open(config Config) (*Log, error) {
+	if config.Path == "" {
+		return nil, errors.New("audit: no path configured")
+	}
+	if err := os.MkdirAll(filepath.Dir(config.Path), 0755); err != nil {
+		return nil, err
+	}
+	l := &Log{config: config}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	if config.HashChain {
+		hash, err := l.findLastHash()
+		if err != nil {
+			l.file.Close()
+			return nil, err
+		}
+		l.lastHash = hash
+	}
+	return l, nil
+}
+
+func (l *Log) open() error {
+	file, err := os.OpenFile(l.config.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+// Write appends the record to the log.
+func (l *Log) Write(r Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return os.ErrClosed
+	}
+
+	r.Time = r.Time.UTC()
+	r.Hash = ""
+	r.PrevHash = ""
+	if l.config.HashChain {
+		r.PrevHash = l.lastHash
+		hash, err := hashRecord(r)
+		if err != nil {
+			return err
+		}
+		r.Hash = hash
+	}
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if l.config.MaxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.config.MaxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		return err
+	}
+	l.lastHash = r.Hash
+	return nil
+}
+
+// Close closes the log file.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// rotate moves the current file aside under a timestamped name and starts a new one.
+func (l *Log) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	backup := l.config.Path + "." + time.Now().UTC().Format(backupLayout)
+	if err := os.Rename(l.config.Path, backup); err != nil {
+		return err
+	}
+	if err := l.open(); err != nil {
+		return err
+	}
+	if l.config.MaxBackups <= 0 {
+		return nil
+	}
+	backups, err := l.backups()
+	if err != nil {
+		return err
+	}
+	for len(backups) > l.config.MaxBackups {
+		os.Remove(backups[0])
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// backupLayout ... The timestamp rotated files are suffixed with, which sorts in time order.
+const backupLayout = "20060102T150405.000000000"
+
+// backups lists the rotated files, oldest first. Other files sharing the name of the log, such
+// as a copy made by hand, are left out.
+func (l *Log) backups() ([]string, error) {
+	dir, name := filepath.Split(l.config.Path)
+	entries, err := os.ReadDir(filepath.Clean(dir))
+	if err != nil {
+		return nil, err
+	}
+	var matches []string
+	for _, entry := range entries {
+		suffix, found := strings.CutPrefix(entry.Name(), name+".")
+		if !found || entry.IsDir() {
+			continue
+		}
+		if _, err := time.Parse(backupLayout, suffix); err != nil || len(suffix) != len(backupLayout) {
+			continue
+		}
+		matches = append(matches, filepath.Join(dir, entry.Name()))
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// findLastHash returns the hash of the last record written, looking at the most recent
+// backup when the current file is still empty.
+func (l *Log) findLastHash() (string, error) {
+	files := []string{l.config.Path}
+	backups, err := l.backups()
+	if err != nil {
+		return "", err
+	}
+	for i := len(backups) - 1; i >= 0; i-- {
+		files = append(files, backups[i])
+	}
+	for _, file := range files {
+		line, err := lastLine(file)
+		if err != nil {
+			return "", err
+		}
+		if len(line) == 0 {
+			continue
+		}
+		var r Record
+		if err := json.Unmarshal(line, &r); err != nil {
+			return "", fmt.Errorf("audit: cannot continue hash chain from %s: %w", file, err)
+		}
+		return r.Hash, nil
+	}
+	return "", nil
+}
+
+func lastLine(file string) ([]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// Read backwards in chunks until a complete line is found.
+	const chunk = 64 << 10
+	var buf []byte
+	for end := info.Size(); end > 0; {
+		start := max(end-chunk, 0)
+		b := make([]byte, end-start)
+		if _, err := f.ReadAt(b, start); err != nil && err != io.EOF {
+			return nil, err
+		}
+		buf = append(b, buf...)
+		trimmed := bytes.TrimRight(buf, "\n")
+		if i := bytes.LastIndexByte(trimmed, '\n'); i >= 0 {
+			return trimmed[i+1:], nil
+		}
+		if start == 0 {
+			return trimmed, nil
+		}
+		end = start
+	}
+	return nil, nil
+}
+
+func hashRecord(r Record) (string, error) {
+	r.Hash = ""
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write([]byte(r.PrevHash))
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Verify checks the hash chain of the records read from r, which must start with a record
+// chained to prevHash. It returns the hash of the last record, so that rotated files can be
+// verified one after the other.
+func Verify(r io.Reader, prevHash string) (string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return "", fmt.Errorf("line %d: %w", line, err)
+		}
+		if record.PrevHash != prevHash {
+			return "", fmt.Errorf("line %d: chain broken, expected previous hash %q", line, prevHash)
+		}
+		hash, err := hashRecord(record)
+		if err != nil {
+			return "", fmt.Errorf("line %d: %w", line, err)
+		}
+		if hash != record.Hash {
+			return "", fmt.Errorf("line %d: record has been altered", line)
+		}
+		prevHash = record.Hash
+	}
+	return prevHash, scanner.Err()
+}
@@ -0,0 +1,52 @@
+package audit
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"github.com/pkg/sftp"
+
+	"github.com/oarkflow/ftp-server/errs"
+)
+
+var statuses = []struct {
+	err    error
+	code   uint32
+	result string
+}{
+	{sftp.ErrSshFxOk, 0, "ok"},
+	{sftp.ErrSshFxEof, 1, "eof"},
+	{sftp.ErrSshFxNoSuchFile, 2, "no_such_file"},
+	{sftp.ErrSshFxPermissionDenied, 3, "permission_denied"},
+	{sftp.ErrSshFxFailure, 4, "failure"},
+	{sftp.ErrSshFxBadMessage, 5, "bad_message"},
+	{sftp.ErrSshFxNoConnection, 6, "no_connection"},
+	{sftp.ErrSshFxConnectionLost, 7, "connection_lost"},
+	{sftp.ErrSshFxOpUnsupported, 8, "op_unsupported"},
+	{errs.ErrSSHQuotaExceeded, uint32(errs.ErrSSHQuotaExceeded), "quota_exceeded"},
+	{errs.ErrSSHLockConflict, uint32(errs.ErrSSHLockConflict), "lock_conflict"},
+	{errs.InvalidCredentialsError{}, 3, "invalid_credentials"},
+}
+
+// Status maps the error an operation returned to the SFTP status code sent to the client,
+// along with a short name for it.
+func Status(err error) (uint32, string) {
+	if err == nil {
+		return 0, "ok"
+	}
+	for _, s := range statuses {
+		if errors.Is(err, s.err) {
+			return s.code, s.result
+		}
+	}
+	switch {
+	case errors.Is(err, io.EOF):
+		return 1, "eof"
+	case os.IsNotExist(err):
+		return 2, "no_such_file"
+	case os.IsPermission(err):
+		return 3, "permission_denied"
+	}
+	return 4, "failure"
+}
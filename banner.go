@@ -0,0 +1,71 @@
+package ftpserver
+
+import (
+	"bytes"
+	"strings"
+	"text/template"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// LoginMessage ... The values available to the post-login message template, e.g.
+// "Welcome {{.User}}, last connected from {{.RemoteAddr}}".
+type LoginMessage struct {
+	User          string
+	RemoteAddr    string
+	ClientVersion string
+	LoginAt       string
+	AuthMethod    string
+}
+
+// parseLoginMessage checks the post-login message template so that mistakes surface when the
+// server starts rather than on the first login.
+func (c *Server) parseLoginMessage() error {
+	if c.loginMessage == "" {
+		return nil
+	}
+	tmpl, err := template.New("login_message").Option("missingkey=error").Parse(c.loginMessage)
+	if err != nil {
+		return err
+	}
+	c.loginTemplate = tmpl
+	return nil
+}
+
+// banner returns the BannerCallback showing the banner before authentication, nil without one.
+func (c *Server) banner(text string) func(ssh.ConnMetadata) string {
+	if text == "" {
+		return nil
+	}
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	return func(ssh.ConnMetadata) string {
+		return text
+	}
+}
+
+// sendLoginMessage writes the post-login message to the stderr of the session channel, which
+// SFTP clients show to the user.
+func (c *Server) sendLoginMessage(channel ssh.Channel, sconn *ssh.ServerConn) {
+	if c.loginTemplate == nil {
+		return
+	}
+	ext := sconn.Permissions.Extensions
+	var buf bytes.Buffer
+	err := c.loginTemplate.Execute(&buf, LoginMessage{
+		User:          sconn.User(),
+		RemoteAddr:    ext["remote_addr"],
+		ClientVersion: ext["client_version"],
+		LoginAt:       ext["login_at"],
+		AuthMethod:    ext["auth_method"],
+	})
+	if err != nil {
+		c.logger.Error("could not render login message", "user", sconn.User(), "err", err)
+		return
+	}
+	if buf.Len() > 0 && !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+		buf.WriteByte('\n')
+	}
+	channel.Stderr().Write(buf.Bytes())
+}
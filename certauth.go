@@ -0,0 +1,94 @@
+package ftpserver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/oarkflow/ftp-server/providers"
+)
+
+// ErrNotCertificate ... Returned for public keys that are not certificates, only certificates
+// issued by a trusted user CA are accepted.
+var ErrNotCertificate = errors.New("public key is not a user certificate")
+
+// LoadUserCAs reads the public keys of trusted user certificate authorities from a file in the
+// authorized_keys format, such as the TrustedUserCAKeys file of sshd.
+func LoadUserCAs(file string) ([]ssh.PublicKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var keys []ssh.PublicKey
+	for len(bytes.TrimSpace(data)) > 0 {
+		key, _, _, rest, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("user CA file %s: %w", file, err)
+		}
+		keys = append(keys, key)
+		data = rest
+	}
+	return keys, nil
+}
+
+func (c *Server) isUserCA(auth ssh.PublicKey) bool {
+	for _, key := range c.userCAs {
+		if bytes.Equal(key.Marshal(), auth.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateCertificate ... Authenticates a public key login made with an OpenSSH user
+// certificate. The certificate must be signed by a trusted user CA, list the login name among
+// its principals, be within its validity window and carry no critical option the server does
+// not enforce. The login name is then looked up with the user provider.
+func (c *Server) ValidateCertificate(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		// Clients offer all their keys in turn, this is not worth a failed login.
+		return nil, ErrNotCertificate
+	}
+	checker := &ssh.CertChecker{
+		IsUserAuthority: c.isUserCA,
+		// source-address is enforced by the SSH server itself from the returned permissions.
+		SupportedCriticalOptions: []string{"source-address", "force-command"},
+	}
+	perms, err := checker.Authenticate(conn, cert)
+	if err == nil {
+		err = checkForceCommand(perms.CriticalOptions["force-command"])
+	}
+	if err != nil {
+		return nil, c.loginFailed(conn, fmt.Errorf("certificate %q: %w", cert.KeyId, err))
+	}
+	lookup, ok := c.userProvider.(providers.UserLookup)
+	if !ok {
+		return nil, c.loginFailed(conn, errors.New("the user provider does not support certificate logins"))
+	}
+	resp, err := lookup.Lookup(conn.User())
+	if err != nil {
+		return nil, c.loginFailed(conn, err)
+	}
+	sshPerm, err := c.authorize(conn, "certificate", resp)
+	if err != nil {
+		return nil, err
+	}
+	sshPerm.CriticalOptions = perms.CriticalOptions
+	sshPerm.Extensions["cert_key_id"] = cert.KeyId
+	return sshPerm, nil
+}
+
+// checkForceCommand accepts a forced command only when it is the SFTP subsystem, the only
+// thing this server runs.
+func checkForceCommand(command string) error {
+	switch strings.TrimSpace(command) {
+	case "", "internal-sftp", "sftp":
+		return nil
+	}
+	return fmt.Errorf("unsupported force-command %q", command)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/crypto/ssh"
+
+	ftpserver "github.com/oarkflow/ftp-server"
+	"github.com/oarkflow/ftp-server/config"
+)
+
+func hostKey(args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "show":
+			return hostKeyShow(args[1:])
+		case "generate":
+			return hostKeyGenerate(args[1:])
+		}
+	}
+	fmt.Fprintln(os.Stderr, "Usage: ftp-server hostkey show|generate [arguments]")
+	return errUsage
+}
+
+// configuredServer creates the server described by the configuration file, without starting it.
+func configuredServer(configFile string) (*ftpserver.Server, error) {
+	conf, err := config.Load(configFile)
+	if err != nil {
+		return nil, err
+	}
+	opts := []func(*ftpserver.Server){}
+	if conf.BasePath != "" {
+		opts = append(opts, ftpserver.WithBasePath(conf.BasePath))
+	}
+	if conf.SSHPath != "" {
+		opts = append(opts, ftpserver.WithSSHPath(conf.SSHPath))
+	}
+	if conf.PrivateKey != "" {
+		opts = append(opts, ftpserver.WithPrivateKey(conf.PrivateKey))
+	}
+	if conf.PublicKey != "" {
+		opts = append(opts, ftpserver.WithPublicKey(conf.PublicKey))
+	}
+	if len(conf.HostKeyTypes) > 0 {
+		opts = append(opts, ftpserver.WithHostKeyTypes(conf.HostKeyTypes...))
+	}
+	if len(conf.Listeners) > 0 {
+		opts = append(opts, ftpserver.WithListeners(conf.Listeners...))
+	}
+	return ftpserver.New(opts...), nil
+}
+
+func hostKeyShow(args []string) error {
+	flags, configFile := newFlagSet("hostkey show", "[-config file]")
+	if err := parse(flags, args, 0); err != nil {
+		return err
+	}
+	server, err := configuredServer(*configFile)
+	if err != nil {
+		return err
+	}
+	keys, err := server.HostKeys()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tFINGERPRINT\tFILE")
+	for _, key := range keys {
+		keyType, fingerprint := key.Type, "(not generated)"
+		if key.PublicKey != nil {
+			keyType, fingerprint = key.PublicKey.Type(), ssh.FingerprintSHA256(key.PublicKey)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", keyType, fingerprint, key.File)
+	}
+	return w.Flush()
+}
+
+func hostKeyGenerate(args []string) error {
+	flags, configFile := newFlagSet("hostkey generate", "[-config file] [-type type] [-force]")
+	keyType := flags.String("type", "", "host key type, "+strings.Join(ftpserver.DefaultHostKeyTypes, ", ")+"; all configured types when empty")
+	force := flags.Bool("force", false, "replace existing keys")
+	if err := parse(flags, args, 0); err != nil {
+		return err
+	}
+	server, err := configuredServer(*configFile)
+	if err != nil {
+		return err
+	}
+	var types []string
+	if *keyType != "" {
+		types = []string{*keyType}
+	} else {
+		keys, err := server.HostKeys()
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if key.Type != "" && (key.PublicKey == nil || *force) {
+				types = append(types, key.Type)
+			}
+		}
+	}
+	for _, t := range types {
+		key, err := server.GenerateHostKey(t, *force)
+		if err != nil {
+			return fmt.Errorf("%s host key: %w", t, err)
+		}
+		fmt.Printf("generated %s %s %s\n", key.PublicKey.Type(), ssh.FingerprintSHA256(key.PublicKey), key.File)
+	}
+	if len(types) == 0 {
+		fmt.Println("all host keys already exist, use -force to replace them")
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+// Command ftp-server runs the SFTP server from a configuration file and administers its users
+// and host keys.
+//
+// Usage:
+//
+//	ftp-server serve [-config file]
+//	ftp-server check-config [-config file]
+//	ftp-server user list [-config file]
+//	ftp-server user add [-config file] [flags] <username>
+//	ftp-server user passwd [-config file] <username>
+//	ftp-server user disable|enable [-config file] <username>
+//	ftp-server hostkey show [-config file]
+//	ftp-server hostkey generate [-config file] [-type type] [-force]
+//	ftp-server hash-password [-algo algorithm]
+//
+// Passwords are read from the terminal, or from the first line of standard input when it is
+// not a terminal. While serving, SIGUSR1 switches the server to read-only mode and SIGUSR2
+// back. User commands edit the file of user_provider.file; a running server picks up
+// the changes when it is restarted.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// errUsage ... Returned when the command line is invalid, the usage has already been printed.
+var errUsage = errors.New("invalid usage")
+
+type command struct {
+	name        string
+	description string
+	run         func(args []string) error
+}
+
+var commands = []command{
+	{"serve", "run the server", serve},
+	{"check-config", "validate the configuration file", checkConfig},
+	{"user", "list, add, disable and enable users, change their password", user},
+	{"hostkey", "show or generate host keys", hostKey},
+	{"hash-password", "hash a password for the users file", hashPassword},
+}
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		if !errors.Is(err, errUsage) {
+			fmt.Fprintln(os.Stderr, "ftp-server:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	if len(args) > 0 {
+		for _, cmd := range commands {
+			if cmd.name == args[0] {
+				return cmd.run(args[1:])
+			}
+		}
+	}
+	fmt.Fprintln(os.Stderr, "Usage: ftp-server <command> [arguments]\n\nCommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-14s %s\n", cmd.name, cmd.description)
+	}
+	return errUsage
+}
+
+// newFlagSet creates the flags of a command, with the -config flag most of them share.
+func newFlagSet(name, usage string) (*flag.FlagSet, *string) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: ftp-server %s %s\n", name, usage)
+		flags.PrintDefaults()
+	}
+	configFile := flags.String("config", "config.json", "configuration file, JSON or YAML")
+	return flags, configFile
+}
+
+// parse parses the arguments of a command, expecting nargs positional arguments.
+func parse(flags *flag.FlagSet, args []string, nargs int) error {
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if flags.NArg() != nargs {
+		flags.Usage()
+		return errUsage
+	}
+	return nil
+}
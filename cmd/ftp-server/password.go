@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+
+	"github.com/oarkflow/ftp-server/providers"
+)
+
+// readPassword reads a password and hashes it with the algorithm of the provider. On a terminal
+// the password is not echoed, and entered twice when confirm is set; otherwise it is the first
+// line of standard input.
+func readPassword(provider *providers.JsonFileProvider, confirm bool) (string, error) {
+	var password string
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, "Password: ")
+		entered, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		if confirm {
+			fmt.Fprint(os.Stderr, "Confirm password: ")
+			again, err := term.ReadPassword(fd)
+			fmt.Fprintln(os.Stderr)
+			if err != nil {
+				return "", err
+			}
+			if string(again) != string(entered) {
+				return "", errors.New("passwords do not match")
+			}
+		}
+		password = string(entered)
+	} else {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("reading password: %w", err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+	if password == "" {
+		return "", errors.New("empty password")
+	}
+	return provider.HashPassword(password)
+}
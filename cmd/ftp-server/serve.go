@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	ftpserver "github.com/oarkflow/ftp-server"
+	"github.com/oarkflow/ftp-server/config"
+)
+
+// serve runs the server until it fails or is interrupted. SIGUSR1 switches the server to
+// read-only mode and SIGUSR2 back, on platforms that have them.
+func serve(args []string) error {
+	flags, configFile := newFlagSet("serve", "[-config file]")
+	if err := parse(flags, args, 0); err != nil {
+		return err
+	}
+	conf, err := config.Load(*configFile)
+	if err != nil {
+		return err
+	}
+	server, err := conf.NewServer()
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Initialize()
+	}()
+	defer notifyReadOnly(server)()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+	}
+	if closeErr := server.Close(); err == nil {
+		err = closeErr
+	}
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// checkConfig validates the configuration along with the files it refers to, without opening
+// any log or listener.
+func checkConfig(args []string) error {
+	flags, configFile := newFlagSet("check-config", "[-config file]")
+	if err := parse(flags, args, 0); err != nil {
+		return err
+	}
+	conf, err := config.Load(*configFile)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	if conf.UserProvider.File != "" {
+		if _, err := config.LoadUsers(conf.UserProvider.File); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for i, file := range conf.UserCAs {
+		if _, err := ftpserver.LoadUserCAs(file); err != nil {
+			errs = append(errs, &config.FieldError{Path: fmt.Sprintf("user_cas[%d]", i), Err: err})
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("%s: %w", *configFile, err)
+	}
+	fmt.Printf("%s: configuration is valid\n", *configFile)
+	return nil
+}
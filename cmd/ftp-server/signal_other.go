@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import ftpserver "github.com/oarkflow/ftp-server"
+
+// notifyReadOnly does nothing, the platform has no signals to toggle read-only mode with.
+func notifyReadOnly(server *ftpserver.Server) func() {
+	return func() {}
+}
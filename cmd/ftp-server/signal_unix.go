@@ -0,0 +1,27 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	ftpserver "github.com/oarkflow/ftp-server"
+)
+
+// notifyReadOnly switches server to read-only mode on SIGUSR1 and back on SIGUSR2, until the
+// function it returns is called.
+func notifyReadOnly(server *ftpserver.Server) func() {
+	toggle := make(chan os.Signal, 1)
+	signal.Notify(toggle, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range toggle {
+			server.SetReadOnly(sig == syscall.SIGUSR1)
+		}
+	}()
+	return func() {
+		signal.Stop(toggle)
+		close(toggle)
+	}
+}
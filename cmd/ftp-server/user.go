@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/oarkflow/ftp-server/config"
+	"github.com/oarkflow/ftp-server/fs"
+	"github.com/oarkflow/ftp-server/models"
+	"github.com/oarkflow/ftp-server/providers"
+)
+
+var userCommands = []command{
+	{"list", "list the users", userList},
+	{"add", "add a user", userAdd},
+	{"passwd", "change the password of a user", userPasswd},
+	{"disable", "prevent a user from logging in", func(args []string) error { return userSetDisabled("disable", args, true) }},
+	{"enable", "allow a disabled user to log in again", func(args []string) error { return userSetDisabled("enable", args, false) }},
+}
+
+func user(args []string) error {
+	if len(args) > 0 {
+		for _, cmd := range userCommands {
+			if cmd.name == args[0] {
+				return cmd.run(args[1:])
+			}
+		}
+	}
+	fmt.Fprintln(os.Stderr, "Usage: ftp-server user <command> [arguments]\n\nCommands:")
+	for _, cmd := range userCommands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", cmd.name, cmd.description)
+	}
+	return errUsage
+}
+
+// userFile ... The users file of the configured provider, loaded for editing.
+type userFile struct {
+	path     string
+	provider *providers.JsonFileProvider
+}
+
+func openUserFile(configFile string) (*userFile, error) {
+	conf, err := config.Load(configFile)
+	if err != nil {
+		return nil, err
+	}
+	path := conf.UserProvider.File
+	if path == "" {
+		return nil, fmt.Errorf("%s: user_provider.file is not set, users declared in the configuration itself must be edited there", configFile)
+	}
+	users, err := config.LoadUsers(path)
+	if errors.Is(err, os.ErrNotExist) {
+		users, err = map[string]models.User{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &userFile{path: path, provider: providers.NewJsonFileProvider(conf.UserProvider.HashAlgo, "", users)}, nil
+}
+
+// update applies change to an existing user and saves the file.
+func (f *userFile) update(username string, change func(*models.User) error) error {
+	user, exists := f.provider.User(username)
+	if !exists {
+		return fmt.Errorf("no user %q in %s", username, f.path)
+	}
+	if err := change(&user); err != nil {
+		return err
+	}
+	f.provider.Register(user)
+	return f.provider.Save(f.path)
+}
+
+func userList(args []string) error {
+	flags, configFile := newFlagSet("user list", "[-config file]")
+	if err := parse(flags, args, 0); err != nil {
+		return err
+	}
+	file, err := openUserFile(*configFile)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "USERNAME\tFILESYSTEMS\tSTATUS")
+	for _, user := range file.provider.Users() {
+		var filesystems []string
+		for _, filesystem := range user.Filesystems {
+			filesystems = append(filesystems, filesystem.Fs)
+		}
+		status := "enabled"
+		if user.Disabled {
+			status = "disabled"
+		}
+		if user.ReadOnly {
+			status += ",read-only"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", user.Username, strings.Join(filesystems, ","), status)
+	}
+	return w.Flush()
+}
+
+func userAdd(args []string) error {
+	flags, configFile := newFlagSet("user add", "[-config file] [flags] <username>")
+	fsType := flags.String("fs", "os", "filesystem type, os, s3 or memory")
+	basePath := flags.String("base-path", "", "directory of the user, for the os filesystem")
+	permissions := flags.String("permissions", strings.Join([]string{fs.Read, fs.ReadContent, fs.Create, fs.Update, fs.Delete}, ","), "comma separated permissions")
+	uploadRate := flags.Int64("upload-rate", 0, "upload bandwidth limit in bytes per second, 0 for none")
+	downloadRate := flags.Int64("download-rate", 0, "download bandwidth limit in bytes per second, 0 for none")
+	readOnly := flags.Bool("read-only", false, "only allow listing and downloading files")
+	params := map[string]any{}
+	flags.Func("param", "filesystem parameter as key=value, e.g. bucket=uploads for s3, repeatable", func(value string) error {
+		key, val, ok := strings.Cut(value, "=")
+		if !ok {
+			return errors.New("expected key=value")
+		}
+		params[key] = val
+		return nil
+	})
+	if err := parse(flags, args, 1); err != nil {
+		return err
+	}
+	file, err := openUserFile(*configFile)
+	if err != nil {
+		return err
+	}
+	username := flags.Arg(0)
+	if _, exists := file.provider.User(username); exists {
+		return fmt.Errorf("user %q already exists in %s", username, file.path)
+	}
+	if *basePath != "" {
+		params["base_path"] = *basePath
+	}
+	user := models.User{
+		Username: username,
+		Filesystems: []*models.Filesystem{{
+			Fs:          *fsType,
+			Permissions: strings.Split(*permissions, ","),
+			Params:      params,
+		}},
+		DefaultFilesystem: *fsType,
+		UploadRate:        *uploadRate,
+		DownloadRate:      *downloadRate,
+		ReadOnly:          *readOnly,
+	}
+	// Validate before asking for the password, with a placeholder standing in for it.
+	user.Password = "-"
+	if err := config.ValidateUser(user); err != nil {
+		return err
+	}
+	if user.Password, err = readPassword(file.provider, true); err != nil {
+		return err
+	}
+	file.provider.Register(user)
+	if err := file.provider.Save(file.path); err != nil {
+		return err
+	}
+	fmt.Printf("added user %s\n", username)
+	return nil
+}
+
+func userPasswd(args []string) error {
+	flags, configFile := newFlagSet("user passwd", "[-config file] <username>")
+	if err := parse(flags, args, 1); err != nil {
+		return err
+	}
+	file, err := openUserFile(*configFile)
+	if err != nil {
+		return err
+	}
+	return file.update(flags.Arg(0), func(user *models.User) error {
+		password, err := readPassword(file.provider, true)
+		user.Password = password
+		return err
+	})
+}
+
+func userSetDisabled(name string, args []string, disabled bool) error {
+	flags, configFile := newFlagSet("user "+name, "[-config file] <username>")
+	if err := parse(flags, args, 1); err != nil {
+		return err
+	}
+	file, err := openUserFile(*configFile)
+	if err != nil {
+		return err
+	}
+	return file.update(flags.Arg(0), func(user *models.User) error {
+		user.Disabled = disabled
+		return nil
+	})
+}
+
+func hashPassword(args []string) error {
+	flags := flag.NewFlagSet("hash-password", flag.ContinueOnError)
+	algo := flags.String("algo", "sha256", "hash algorithm, as user_provider.hash_algo")
+	if err := parse(flags, args, 0); err != nil {
+		return err
+	}
+	hashed, err := readPassword(providers.NewJsonFileProvider(*algo, ""), false)
+	if err != nil {
+		return err
+	}
+	fmt.Println(hashed)
+	return nil
+}
@@ -0,0 +1,153 @@
+// Package config loads the configuration of the server from a JSON or YAML file, validates it
+// and turns it into server options.
+//
+// Unknown fields are rejected, and every problem found is reported with the path of the value
+// at fault, e.g. "listeners[1].auth_methods[0]". Durations are written as strings such as
+// "30s" or "5m"; plain numbers are taken as nanoseconds, like encoding/json does.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	ftpserver "github.com/oarkflow/ftp-server"
+	"github.com/oarkflow/ftp-server/audit"
+	"github.com/oarkflow/ftp-server/models"
+	"github.com/oarkflow/ftp-server/tracing"
+	"github.com/oarkflow/ftp-server/xferlog"
+)
+
+// Format ... The syntax of a configuration file.
+type Format string
+
+const (
+	// FormatJSON ... A JSON document.
+	FormatJSON Format = "json"
+	// FormatYAML ... A YAML document.
+	FormatYAML Format = "yaml"
+)
+
+// Config ... The configuration of a server. Fields left empty keep the defaults of the server.
+type Config struct {
+	Address  string `json:"address"`
+	Port     int    `json:"port"`
+	BasePath string `json:"base_path"`
+	// SSHPath is the directory holding the host keys, relative to BasePath.
+	SSHPath    string `json:"ssh_path"`
+	PrivateKey string `json:"private_key"`
+	PublicKey  string `json:"public_key"`
+	// HostKeyTypes are the types of host keys offered, generated when missing.
+	HostKeyTypes []string `json:"host_key_types"`
+	// UserCAs are files in the authorized_keys format listing the certificate authorities
+	// trusted to sign user certificates.
+	UserCAs      []string                `json:"user_cas"`
+	CryptoPolicy *ftpserver.CryptoPolicy `json:"crypto_policy"`
+	Banner       string                  `json:"banner"`
+	// LoginMessage is a text/template executed with a ftpserver.LoginMessage.
+	LoginMessage string `json:"login_message"`
+	// ReadOnly starts the server in read-only mode, refusing every write and change.
+	ReadOnly bool `json:"read_only"`
+	// TrustedProxies enables the PROXY protocol for connections from these addresses on
+	// listeners that do not configure it themselves.
+	TrustedProxies []string                    `json:"trusted_proxies"`
+	Listeners      []ftpserver.Listener        `json:"listeners"`
+	Timeouts       ftpserver.Timeouts          `json:"timeouts"`
+	Bandwidth      Bandwidth                   `json:"bandwidth"`
+	UserProvider   UserProvider                `json:"user_provider"`
+	Metrics        *Metrics                    `json:"metrics"`
+	Tracing        *tracing.Config             `json:"tracing"`
+	AuditLog       *audit.Config               `json:"audit_log"`
+	TransferLog    *TransferLog                `json:"transfer_log"`
+	Webhooks       []ftpserver.WebhookConfig   `json:"webhooks"`
+	Dispatcher     *ftpserver.DispatcherConfig `json:"dispatcher"`
+	Actions        []ftpserver.Action          `json:"actions"`
+}
+
+// Bandwidth ... The combined bandwidth of all uploads and of all downloads, in bytes per second.
+// Zero leaves a direction unlimited.
+type Bandwidth struct {
+	Upload   int64 `json:"upload"`
+	Download int64 `json:"download"`
+}
+
+// UserProvider ... Where users are looked up.
+type UserProvider struct {
+	// Type is the kind of provider, only "json" is supported and it is the default.
+	Type string `json:"type"`
+	// HashAlgo is the algorithm passwords are hashed with, "sha256" by default.
+	HashAlgo string `json:"hash_algo"`
+	// File is a JSON object of users keyed by username, see examples/sample.users.json.
+	File string `json:"file"`
+	// Users are declared in the configuration itself, in addition to those of File.
+	Users []models.User `json:"users"`
+}
+
+// Metrics ... Where the Prometheus metrics are served.
+type Metrics struct {
+	// Address of the HTTP listener serving /metrics. Metrics are still collected when empty,
+	// for applications serving ftpserver.Server.MetricsHandler themselves.
+	Address string `json:"address"`
+}
+
+// TransferLog ... The log of completed uploads and downloads.
+type TransferLog struct {
+	Path   string         `json:"path"`
+	Format xferlog.Format `json:"format"`
+}
+
+// Load reads the configuration file at path, YAML when its extension is .yaml or .yml and JSON
+// otherwise, and validates it.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	format := FormatJSON
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		format = FormatYAML
+	}
+	config, err := Parse(data, format)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, nil
+}
+
+// Parse decodes and validates a configuration. The error lists every problem found, each as a
+// *FieldError.
+func Parse(data []byte, format Format) (*Config, error) {
+	var tree any
+	switch format {
+	case FormatJSON, "":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&tree); err != nil {
+			return nil, err
+		}
+	case FormatYAML:
+		if err := yaml.Unmarshal(data, &tree); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown configuration format %q", format)
+	}
+	config := &Config{}
+	var errs errorList
+	if err := decode(tree, config, &errs); err != nil {
+		return nil, err
+	}
+	var invalid errorList
+	config.validate(&invalid)
+	errs.extend(invalid)
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
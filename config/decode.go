@@ -0,0 +1,262 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// FieldError ... A problem with the value at Path, e.g. "listeners[1].port".
+type FieldError struct {
+	Path string
+	Err  error
+}
+
+func (e *FieldError) Error() string {
+	if e.Path == "" {
+		return e.Err.Error()
+	}
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// errorList ... Collects the problems found in a configuration.
+type errorList []error
+
+func (l *errorList) add(path string, format string, args ...any) {
+	*l = append(*l, &FieldError{Path: path, Err: fmt.Errorf(format, args...)})
+}
+
+// err joins the problems, ordered by path so that they read in the order of the file.
+func (l errorList) err() error {
+	slices.SortStableFunc(l, func(a, b error) int {
+		return strings.Compare(a.(*FieldError).Path, b.(*FieldError).Path)
+	})
+	return errors.Join(l...)
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// extend adds the problems in more, leaving out those about a value a problem in l is already
+// about, or one of its fields. A value dropped by decode is typically reported as missing by
+// the validation that follows.
+func (l *errorList) extend(more errorList) {
+	reported := make([]string, len(*l))
+	for i, err := range *l {
+		reported[i] = err.(*FieldError).Path
+	}
+	for _, err := range more {
+		p := err.(*FieldError).Path
+		if !slices.ContainsFunc(reported, func(r string) bool { return within(p, r) }) {
+			*l = append(*l, err)
+		}
+	}
+}
+
+// within reports whether path is that of parent or of a value it holds.
+func within(path, parent string) bool {
+	if parent == "" || path == parent {
+		return true
+	}
+	rest, ok := strings.CutPrefix(path, parent)
+	return ok && (rest[0] == '.' || rest[0] == '[')
+}
+
+// decode stores the decoded JSON or YAML document tree in target. The tree is first checked
+// against the type of target, rejecting unknown fields and values of the wrong kind and
+// converting durations, so that encoding/json can then fill target without surprises. The
+// problems found are added to errs, and the values they are about left zero in target, so
+// that the rest of it can still be validated.
+func decode(tree any, target any, errs *errorList) error {
+	normalized := normalize(tree, reflect.TypeOf(target).Elem(), "", errs)
+	data, err := json.Marshal(normalized)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
+
+func normalize(value any, t reflect.Type, path string, errs *errorList) any {
+	if value == nil {
+		return nil
+	}
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == durationType {
+		switch v := value.(type) {
+		case string:
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs.add(path, "invalid duration %q", v)
+				return nil
+			}
+			return int64(d)
+		default:
+			if n, ok := integer(value); ok {
+				return n
+			}
+			errs.add(path, "expected a duration such as \"30s\", got %s", describe(value))
+			return nil
+		}
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		object, ok := value.(map[string]any)
+		if !ok {
+			errs.add(path, "expected an object, got %s", describe(value))
+			return nil
+		}
+		fields := jsonFields(t)
+		out := make(map[string]any, len(object))
+		for key, v := range object {
+			field, ok := fields[key]
+			if !ok {
+				errs.add(join(path, key), "unknown field")
+				continue
+			}
+			out[key] = normalize(v, field.Type, join(path, key), errs)
+		}
+		return out
+	case reflect.Map:
+		object, ok := value.(map[string]any)
+		if !ok {
+			errs.add(path, "expected an object, got %s", describe(value))
+			return nil
+		}
+		out := make(map[string]any, len(object))
+		for key, v := range object {
+			out[key] = normalize(v, t.Elem(), fmt.Sprintf("%s[%q]", path, key), errs)
+		}
+		return out
+	case reflect.Slice, reflect.Array:
+		list, ok := value.([]any)
+		if !ok {
+			errs.add(path, "expected a list, got %s", describe(value))
+			return nil
+		}
+		out := make([]any, len(list))
+		for i, v := range list {
+			out[i] = normalize(v, t.Elem(), fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+		return out
+	case reflect.String:
+		if _, ok := value.(string); !ok {
+			errs.add(path, "expected a string, got %s", describe(value))
+			return nil
+		}
+		return value
+	case reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			errs.add(path, "expected true or false, got %s", describe(value))
+			return nil
+		}
+		return value
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, ok := integer(value)
+		if !ok {
+			errs.add(path, "expected an integer, got %s", describe(value))
+			return nil
+		}
+		return n
+	case reflect.Float32, reflect.Float64:
+		f, ok := float(value)
+		if !ok {
+			errs.add(path, "expected a number, got %s", describe(value))
+			return nil
+		}
+		return f
+	case reflect.Interface:
+		return value
+	}
+	errs.add(path, "unsupported field")
+	return nil
+}
+
+// jsonFields indexes the fields of a struct by their JSON name, leaving out those that cannot
+// be configured.
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field
+	}
+	return fields
+}
+
+func integer(value any) (int64, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		n, err := v.Int64()
+		return n, err == nil
+	case int:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint64:
+		return int64(v), v <= math.MaxInt64
+	case float64:
+		return int64(v), v == math.Trunc(v)
+	}
+	return 0, false
+}
+
+func float(value any) (float64, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// describe names the kind of a decoded value for error messages.
+func describe(value any) string {
+	switch v := value.(type) {
+	case string:
+		return fmt.Sprintf("string %q", v)
+	case bool:
+		return fmt.Sprint(v)
+	case json.Number, int, int64, uint64, float64:
+		return fmt.Sprintf("number %v", v)
+	case []any:
+		return "a list"
+	case map[string]any:
+		return "an object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
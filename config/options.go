@@ -0,0 +1,184 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	ftpserver "github.com/oarkflow/ftp-server"
+	"github.com/oarkflow/ftp-server/audit"
+	"github.com/oarkflow/ftp-server/models"
+	"github.com/oarkflow/ftp-server/providers"
+	"github.com/oarkflow/ftp-server/tracing"
+	"github.com/oarkflow/ftp-server/xferlog"
+)
+
+// NewServer creates a server configured as described.
+func (c *Config) NewServer() (*ftpserver.Server, error) {
+	opts, err := c.Options()
+	if err != nil {
+		return nil, err
+	}
+	return ftpserver.New(opts...), nil
+}
+
+// Options returns the server options described by the configuration. The audit log, transfer
+// log and tracer provider it opens are handed over to the server, which closes them in Close.
+func (c *Config) Options() ([]func(*ftpserver.Server), error) {
+	var opts []func(*ftpserver.Server)
+	add := func(opt func(*ftpserver.Server)) {
+		opts = append(opts, opt)
+	}
+	if c.Address != "" {
+		add(ftpserver.WithAddress(c.Address))
+	}
+	if c.Port != 0 {
+		add(ftpserver.WithPort(c.Port))
+	}
+	if c.BasePath != "" {
+		add(ftpserver.WithBasePath(c.BasePath))
+	}
+	if c.SSHPath != "" {
+		add(ftpserver.WithSSHPath(c.SSHPath))
+	}
+	if c.PrivateKey != "" {
+		add(ftpserver.WithPrivateKey(c.PrivateKey))
+	}
+	if c.PublicKey != "" {
+		add(ftpserver.WithPublicKey(c.PublicKey))
+	}
+	if len(c.HostKeyTypes) > 0 {
+		add(ftpserver.WithHostKeyTypes(c.HostKeyTypes...))
+	}
+	for i, file := range c.UserCAs {
+		keys, err := ftpserver.LoadUserCAs(file)
+		if err != nil {
+			return nil, &FieldError{Path: fmt.Sprintf("user_cas[%d]", i), Err: err}
+		}
+		add(ftpserver.WithUserCAs(keys...))
+	}
+	if c.CryptoPolicy != nil {
+		add(ftpserver.WithCryptoPolicy(*c.CryptoPolicy))
+	}
+	if c.Banner != "" {
+		add(ftpserver.WithBanner(c.Banner))
+	}
+	if c.LoginMessage != "" {
+		add(ftpserver.WithLoginMessage(c.LoginMessage))
+	}
+	if c.ReadOnly {
+		add(ftpserver.WithReadOnly(true))
+	}
+	if len(c.TrustedProxies) > 0 {
+		add(ftpserver.WithProxyProtocol(c.TrustedProxies...))
+	}
+	if len(c.Listeners) > 0 {
+		add(ftpserver.WithListeners(c.Listeners...))
+	}
+	if c.Timeouts != (ftpserver.Timeouts{}) {
+		add(ftpserver.WithTimeouts(c.Timeouts))
+	}
+	if c.Bandwidth != (Bandwidth{}) {
+		add(ftpserver.WithBandwidthLimit(c.Bandwidth.Upload, c.Bandwidth.Download))
+	}
+	provider, err := c.UserProvider.build()
+	if err != nil {
+		return nil, err
+	}
+	add(ftpserver.WithUserProvider(provider))
+	if c.Metrics != nil {
+		add(ftpserver.WithMetrics(c.Metrics.Address))
+	}
+	for _, webhook := range c.Webhooks {
+		add(ftpserver.WithWebhook(webhook))
+	}
+	if c.Dispatcher != nil {
+		add(ftpserver.WithNotificationDispatcher(*c.Dispatcher))
+	}
+	if len(c.Actions) > 0 {
+		add(ftpserver.WithActions(c.Actions...))
+	}
+
+	// Resources are opened last, and closed again if a later one cannot be opened, so that
+	// nothing leaks when the configuration turns out to be unusable.
+	var closers []io.Closer
+	fail := func(path string, err error) ([]func(*ftpserver.Server), error) {
+		for i := len(closers) - 1; i >= 0; i-- {
+			closers[i].Close()
+		}
+		return nil, &FieldError{Path: path, Err: err}
+	}
+	if c.AuditLog != nil {
+		log, err := audit.Open(*c.AuditLog)
+		if err != nil {
+			return fail("audit_log", err)
+		}
+		closers = append(closers, log)
+		add(ftpserver.WithAuditLog(log))
+	}
+	if c.TransferLog != nil {
+		log, err := xferlog.Open(c.TransferLog.Path, c.TransferLog.Format)
+		if err != nil {
+			return fail("transfer_log", err)
+		}
+		closers = append(closers, log)
+		add(ftpserver.WithTransferLog(log))
+	}
+	if c.Tracing != nil {
+		tp, err := tracing.NewProvider(context.Background(), *c.Tracing)
+		if err != nil {
+			return fail("tracing", err)
+		}
+		add(ftpserver.WithTracerProvider(tp))
+	}
+	return opts, nil
+}
+
+// build creates the provider holding the users of the file and of the configuration.
+func (p UserProvider) build() (*providers.JsonFileProvider, error) {
+	users := make(map[string]models.User)
+	if p.File != "" {
+		fileUsers, err := LoadUsers(p.File)
+		if err != nil {
+			return nil, err
+		}
+		users = fileUsers
+	}
+	for _, user := range p.Users {
+		users[user.Username] = user
+	}
+	return providers.NewJsonFileProvider(p.HashAlgo, "", users), nil
+}
+
+// LoadUsers reads and validates a JSON object of users keyed by username, such as
+// user_provider.file.
+func LoadUsers(file string) (map[string]models.User, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, &FieldError{Path: "user_provider.file", Err: err}
+	}
+	var tree any
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil, &FieldError{Path: "user_provider.file", Err: fmt.Errorf("%s: %w", file, err)}
+	}
+	users := make(map[string]models.User)
+	var errs errorList
+	if err := decode(tree, &users, &errs); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	var invalid errorList
+	for name, user := range users {
+		p := fmt.Sprintf("[%q]", name)
+		checkUser(&invalid, p, user)
+		if user.Username != name {
+			invalid.add(p+".username", "%q does not match the key %q", user.Username, name)
+		}
+	}
+	errs.extend(invalid)
+	if err := errs.err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return users, nil
+}
@@ -0,0 +1,339 @@
+package config
+
+import (
+	"cmp"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+
+	ftpserver "github.com/oarkflow/ftp-server"
+	"github.com/oarkflow/ftp-server/fs"
+	"github.com/oarkflow/ftp-server/fs/aferofs"
+	"github.com/oarkflow/ftp-server/models"
+	"github.com/oarkflow/ftp-server/tracing"
+	"github.com/oarkflow/ftp-server/xferlog"
+)
+
+var (
+	hostKeyTypes = []string{ftpserver.HostKeyEd25519, ftpserver.HostKeyECDSA, ftpserver.HostKeyRSA}
+	authMethods  = []string{ftpserver.AuthPassword, ftpserver.AuthCertificate}
+	permissions  = []string{fs.Read, fs.ReadContent, fs.Create, fs.Update, fs.Delete}
+	fsTypes      = []string{"os", "s3", "memory"}
+	hashAlgos    = []string{"argon2id", "bcrypt", "md5", "sha1", "sha256", "sha512"}
+)
+
+// Validate checks the configuration, returning every problem found as a *FieldError.
+func (c *Config) Validate() error {
+	var errs errorList
+	c.validate(&errs)
+	return errs.err()
+}
+
+// validate adds the problems of the configuration to errs.
+func (c *Config) validate(errs *errorList) {
+	checkPort(errs, "port", c.Port)
+	checkHostKeyTypes(errs, "host_key_types", c.HostKeyTypes)
+	checkCryptoPolicy(errs, "crypto_policy", c.CryptoPolicy)
+	checkNetworks(errs, "trusted_proxies", c.TrustedProxies)
+	for i, file := range c.UserCAs {
+		if file == "" {
+			errs.add(fmt.Sprintf("user_cas[%d]", i), "empty file name")
+		}
+	}
+	seen := make(map[string]string)
+	for i, l := range c.Listeners {
+		p := fmt.Sprintf("listeners[%d]", i)
+		if l.Name != "" {
+			if other, ok := seen["name "+l.Name]; ok {
+				errs.add(p+".name", "%q is already used by %s", l.Name, other)
+			}
+			seen["name "+l.Name] = p
+		}
+		// Listeners without a port take the one of the server.
+		port := cmp.Or(l.Port, c.Port, ftpserver.DefaultPort)
+		addr := net.JoinHostPort(l.Address, fmt.Sprint(port))
+		if other, ok := seen["addr "+addr]; ok {
+			errs.add(p, "%s is already used by %s", addr, other)
+		}
+		seen["addr "+addr] = p
+		checkPort(errs, p+".port", l.Port)
+		for j, method := range l.AuthMethods {
+			if !slices.Contains(authMethods, method) {
+				errs.add(fmt.Sprintf("%s.auth_methods[%d]", p, j), "unknown method %q, expected one of %s", method, strings.Join(authMethods, ", "))
+			}
+		}
+		if slices.Contains(l.AuthMethods, ftpserver.AuthCertificate) && len(c.UserCAs) == 0 {
+			errs.add(p+".auth_methods", "certificate logins require user_cas")
+		}
+		checkNetworks(errs, p+".allowed_ips", l.AllowedIPs)
+		checkNetworks(errs, p+".denied_ips", l.DeniedIPs)
+		checkNetworks(errs, p+".trusted_proxies", l.TrustedProxies)
+		if l.ProxyProtocol && len(l.TrustedProxies) == 0 {
+			errs.add(p+".trusted_proxies", "required when proxy_protocol is enabled")
+		}
+		checkHostKeyTypes(errs, p+".host_key_types", l.HostKeyTypes)
+		checkCryptoPolicy(errs, p+".crypto_policy", l.CryptoPolicy)
+	}
+	t := c.Timeouts
+	for name, d := range map[string]int64{
+		"handshake":          int64(t.Handshake),
+		"idle":               int64(t.Idle),
+		"max_session":        int64(t.MaxSession),
+		"keepalive_interval": int64(t.KeepaliveInterval),
+	} {
+		if d < 0 {
+			errs.add("timeouts."+name, "must not be negative")
+		}
+	}
+	if t.KeepaliveMaxMissed < 0 {
+		errs.add("timeouts.keepalive_max_missed", "must not be negative")
+	}
+	if c.Bandwidth.Upload < 0 {
+		errs.add("bandwidth.upload", "must not be negative")
+	}
+	if c.Bandwidth.Download < 0 {
+		errs.add("bandwidth.download", "must not be negative")
+	}
+	c.UserProvider.validate(errs)
+	if c.Tracing != nil {
+		switch c.Tracing.Exporter {
+		case tracing.ExporterOTLP, tracing.ExporterStdout:
+		default:
+			errs.add("tracing.exporter", "unknown exporter %q, expected %s or %s", c.Tracing.Exporter, tracing.ExporterOTLP, tracing.ExporterStdout)
+		}
+		if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+			errs.add("tracing.sample_ratio", "must be between 0 and 1")
+		}
+	}
+	if c.AuditLog != nil {
+		if c.AuditLog.Path == "" {
+			errs.add("audit_log.path", "required")
+		}
+		if c.AuditLog.MaxSize < 0 {
+			errs.add("audit_log.max_size", "must not be negative")
+		}
+		if c.AuditLog.MaxBackups < 0 {
+			errs.add("audit_log.max_backups", "must not be negative")
+		}
+	}
+	if c.TransferLog != nil {
+		if c.TransferLog.Path == "" {
+			errs.add("transfer_log.path", "required")
+		}
+		switch c.TransferLog.Format {
+		case "", xferlog.FormatXferlog, xferlog.FormatW3C:
+		default:
+			errs.add("transfer_log.format", "unknown format %q, expected %s or %s", c.TransferLog.Format, xferlog.FormatXferlog, xferlog.FormatW3C)
+		}
+	}
+	for i, webhook := range c.Webhooks {
+		p := fmt.Sprintf("webhooks[%d]", i)
+		if len(webhook.URLs) == 0 {
+			errs.add(p+".urls", "required")
+		}
+		for j, raw := range webhook.URLs {
+			u, err := url.Parse(raw)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				errs.add(fmt.Sprintf("%s.urls[%d]", p, j), "expected an http or https URL, got %q", raw)
+			}
+		}
+		checkPatterns(errs, p+".paths", webhook.Paths)
+		if webhook.Timeout < 0 {
+			errs.add(p+".timeout", "must not be negative")
+		}
+	}
+	if d := c.Dispatcher; d != nil {
+		for name, n := range map[string]int64{
+			"queue_size":      int64(d.QueueSize),
+			"workers":         int64(d.Workers),
+			"max_attempts":    int64(d.MaxAttempts),
+			"backoff":         int64(d.Backoff),
+			"max_backoff":     int64(d.MaxBackoff),
+			"replay_interval": int64(d.ReplayInterval),
+		} {
+			if n < 0 {
+				errs.add("dispatcher."+name, "must not be negative")
+			}
+		}
+	}
+	for i, action := range c.Actions {
+		p := fmt.Sprintf("actions[%d]", i)
+		if action.Pattern == "" {
+			errs.add(p+".pattern", "required")
+		}
+		checkPatterns(errs, p+".pattern", []string{action.Pattern})
+		if (len(action.Command) > 0) == (action.MoveTo != "") {
+			errs.add(p, "exactly one of command and move_to is required")
+		}
+		if err := action.CheckCommand(); err != nil {
+			errs.add(p+".command", "%w", err)
+		}
+		if action.Filesystem != nil {
+			if action.MoveTo == "" {
+				errs.add(p+".filesystem", "only used with move_to")
+			}
+			checkFilesystem(errs, p+".filesystem", action.Filesystem)
+		}
+		if action.Timeout < 0 {
+			errs.add(p+".timeout", "must not be negative")
+		}
+	}
+}
+
+func (p UserProvider) validate(errs *errorList) {
+	if p.Type != "" && p.Type != "json" {
+		errs.add("user_provider.type", "unknown provider %q, only json is supported", p.Type)
+	}
+	if p.HashAlgo != "" && !slices.Contains(hashAlgos, p.HashAlgo) {
+		errs.add("user_provider.hash_algo", "unknown algorithm %q, expected one of %s", p.HashAlgo, strings.Join(hashAlgos, ", "))
+	}
+	seen := make(map[string]int)
+	for i, user := range p.Users {
+		path := fmt.Sprintf("user_provider.users[%d]", i)
+		if j, ok := seen[user.Username]; ok && user.Username != "" {
+			errs.add(path+".username", "%q is already declared by user_provider.users[%d]", user.Username, j)
+		}
+		seen[user.Username] = i
+		checkUser(errs, path, user)
+	}
+}
+
+// ValidateUser checks a user before it is added to the users file.
+func ValidateUser(user models.User) error {
+	var errs errorList
+	checkUser(&errs, "user", user)
+	return errs.err()
+}
+
+// checkUser validates a user declared in the configuration or in the users file.
+func checkUser(errs *errorList, p string, user models.User) {
+	if user.Username == "" {
+		errs.add(p+".username", "required")
+	}
+	if user.Password == "" {
+		errs.add(p+".password", "required")
+	}
+	checkPermissions(errs, p+".permissions", user.Permissions)
+	if user.UploadRate < 0 {
+		errs.add(p+".upload_rate", "must not be negative")
+	}
+	if user.DownloadRate < 0 {
+		errs.add(p+".download_rate", "must not be negative")
+	}
+	found := user.DefaultFilesystem == ""
+	for i, filesystem := range user.Filesystems {
+		if filesystem == nil {
+			errs.add(fmt.Sprintf("%s.filesystems[%d]", p, i), "must not be null")
+			continue
+		}
+		checkFilesystem(errs, fmt.Sprintf("%s.filesystems[%d]", p, i), filesystem)
+		found = found || filesystem.Fs == user.DefaultFilesystem
+	}
+	if !found {
+		errs.add(p+".default_filesystem", "no filesystem of type %q", user.DefaultFilesystem)
+	}
+	if user.Filesystem != nil {
+		checkFilesystem(errs, p+".filesystem", user.Filesystem)
+	}
+}
+
+func checkFilesystem(errs *errorList, p string, filesystem *models.Filesystem) {
+	checkPermissions(errs, p+".permissions", filesystem.Permissions)
+	if backend, registered := aferofs.Lookup(filesystem.Fs); registered {
+		// The values are up to the storage, which checks them when it is opened.
+		for key := range filesystem.Params {
+			if !slices.Contains(backend.Params, key) {
+				errs.add(p+".params."+key, "unknown parameter for %s, expected one of %s", filesystem.Fs, strings.Join(backend.Params, ", "))
+			}
+		}
+		return
+	}
+	if !slices.Contains(fsTypes, filesystem.Fs) {
+		types := append(slices.Clone(fsTypes), aferofs.Backends()...)
+		errs.add(p+".fs", "unknown filesystem %q, expected one of %s", filesystem.Fs, strings.Join(types, ", "))
+		return
+	}
+	params := map[string][]string{
+		"os":     {"base_path", "atomic_uploads"},
+		"s3":     {"endpoint", "region", "bucket", "access_key", "secret"},
+		"memory": {"store", "max_size"},
+	}[filesystem.Fs]
+	for key, value := range filesystem.Params {
+		if !slices.Contains(params, key) {
+			errs.add(p+".params."+key, "unknown parameter for %s, expected one of %s", filesystem.Fs, strings.Join(params, ", "))
+			continue
+		}
+		_, isBool := value.(bool)
+		_, isString := value.(string)
+		switch key {
+		case "atomic_uploads":
+			if !isBool {
+				errs.add(p+".params."+key, "expected true or false, got %s", describe(value))
+			}
+		case "max_size":
+			if n, ok := integer(value); !ok || n < 0 {
+				errs.add(p+".params."+key, "expected a number of bytes, got %s", describe(value))
+			}
+		default:
+			if !isString {
+				errs.add(p+".params."+key, "expected a string, got %s", describe(value))
+			}
+		}
+	}
+	if filesystem.Fs == "s3" && filesystem.Params["bucket"] == nil {
+		errs.add(p+".params.bucket", "required")
+	}
+}
+
+func checkPermissions(errs *errorList, p string, values []string) {
+	for i, value := range values {
+		if !slices.Contains(permissions, value) {
+			errs.add(fmt.Sprintf("%s[%d]", p, i), "unknown permission %q, expected one of %s", value, strings.Join(permissions, ", "))
+		}
+	}
+}
+
+func checkPort(errs *errorList, p string, port int) {
+	if port < 0 || port > 65535 {
+		errs.add(p, "%d is not a valid port", port)
+	}
+}
+
+func checkHostKeyTypes(errs *errorList, p string, types []string) {
+	for i, t := range types {
+		if !slices.Contains(hostKeyTypes, t) {
+			errs.add(fmt.Sprintf("%s[%d]", p, i), "unknown host key type %q, expected one of %s", t, strings.Join(hostKeyTypes, ", "))
+		}
+	}
+}
+
+func checkCryptoPolicy(errs *errorList, p string, policy *ftpserver.CryptoPolicy) {
+	if policy == nil {
+		return
+	}
+	if _, err := policy.Resolve(); err != nil {
+		errs.add(p, "%w", err)
+	}
+}
+
+// checkNetworks validates IP addresses and CIDR ranges.
+func checkNetworks(errs *errorList, p string, values []string) {
+	for i, value := range values {
+		value = strings.TrimSpace(value)
+		if _, _, err := net.ParseCIDR(value); err == nil || net.ParseIP(value) != nil {
+			continue
+		}
+		errs.add(fmt.Sprintf("%s[%d]", p, i), "invalid IP address or CIDR range %q", value)
+	}
+}
+
+func checkPatterns(errs *errorList, p string, patterns []string) {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			errs.add(p, "invalid pattern %q", pattern)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package ftpserver
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Crypto policy presets ... Named sets of algorithms for CryptoPolicy.Preset.
+const (
+	// CryptoPolicyModern only allows AEAD and CTR ciphers, elliptic curve and large group
+	// Diffie-Hellman key exchanges and SHA-2 MACs.
+	CryptoPolicyModern = "modern"
+	// CryptoPolicyCompatible adds the CBC, SHA-1 and ssh-rsa algorithms older clients need.
+	CryptoPolicyCompatible = "compatible"
+	// CryptoPolicyFIPS restricts algorithms to those approved by FIPS 140, AES, NIST curves,
+	// SHA-2 and RSA. It does not make the server FIPS validated.
+	CryptoPolicyFIPS = "fips-like"
+)
+
+// Algorithms known to golang.org/x/crypto/ssh on the server side.
+var (
+	supportedCiphers = []string{
+		"chacha20-poly1305@openssh.com", "aes256-gcm@openssh.com", "aes128-gcm@openssh.com",
+		"aes256-ctr", "aes192-ctr", "aes128-ctr",
+		"aes128-cbc", "3des-cbc", "arcfour256", "arcfour128", "arcfour",
+	}
+	supportedKeyExchanges = []string{
+		"curve25519-sha256", "curve25519-sha256@libssh.org",
+		"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+		"diffie-hellman-group16-sha512", "diffie-hellman-group14-sha256",
+		"diffie-hellman-group14-sha1", "diffie-hellman-group1-sha1",
+	}
+	supportedMACs = []string{
+		"hmac-sha2-256-etm@openssh.com", "hmac-sha2-512-etm@openssh.com",
+		"hmac-sha2-256", "hmac-sha2-512", "hmac-sha1", "hmac-sha1-96",
+	}
+	supportedHostKeyAlgorithms = []string{
+		ssh.KeyAlgoED25519,
+		ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+		ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA,
+	}
+)
+
+var cryptoPresets = map[string]CryptoPolicy{
+	CryptoPolicyModern: {
+		Ciphers: []string{
+			"chacha20-poly1305@openssh.com", "aes256-gcm@openssh.com", "aes128-gcm@openssh.com",
+			"aes256-ctr", "aes192-ctr", "aes128-ctr",
+		},
+		KeyExchanges: []string{
+			"curve25519-sha256", "curve25519-sha256@libssh.org",
+			"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+			"diffie-hellman-group16-sha512", "diffie-hellman-group14-sha256",
+		},
+		MACs: []string{
+			"hmac-sha2-256-etm@openssh.com", "hmac-sha2-512-etm@openssh.com",
+			"hmac-sha2-256", "hmac-sha2-512",
+		},
+		HostKeyAlgorithms: []string{
+			ssh.KeyAlgoED25519,
+			ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+			ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256,
+		},
+	},
+	CryptoPolicyCompatible: {
+		Ciphers: []string{
+			"chacha20-poly1305@openssh.com", "aes256-gcm@openssh.com", "aes128-gcm@openssh.com",
+			"aes256-ctr", "aes192-ctr", "aes128-ctr", "aes128-cbc",
+		},
+		KeyExchanges: []string{
+			"curve25519-sha256", "curve25519-sha256@libssh.org",
+			"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+			"diffie-hellman-group16-sha512", "diffie-hellman-group14-sha256",
+			"diffie-hellman-group14-sha1",
+		},
+		MACs: []string{
+			"hmac-sha2-256-etm@openssh.com", "hmac-sha2-512-etm@openssh.com",
+			"hmac-sha2-256", "hmac-sha2-512", "hmac-sha1",
+		},
+		HostKeyAlgorithms: []string{
+			ssh.KeyAlgoED25519,
+			ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+			ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA,
+		},
+	},
+	CryptoPolicyFIPS: {
+		Ciphers: []string{
+			"aes256-gcm@openssh.com", "aes128-gcm@openssh.com",
+			"aes256-ctr", "aes192-ctr", "aes128-ctr",
+		},
+		KeyExchanges: []string{
+			"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+			"diffie-hellman-group16-sha512", "diffie-hellman-group14-sha256",
+		},
+		MACs: []string{
+			"hmac-sha2-256-etm@openssh.com", "hmac-sha2-512-etm@openssh.com",
+			"hmac-sha2-256", "hmac-sha2-512",
+		},
+		HostKeyAlgorithms: []string{
+			ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+			ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256,
+		},
+	},
+}
+
+// CryptoPolicy ... The algorithms the server negotiates, in order of preference. Lists left
+// empty come from the preset, or keep the defaults of golang.org/x/crypto/ssh without one.
+type CryptoPolicy struct {
+	// Preset is one of CryptoPolicyModern, CryptoPolicyCompatible or CryptoPolicyFIPS.
+	Preset            string   `json:"preset"`
+	Ciphers           []string `json:"ciphers"`
+	KeyExchanges      []string `json:"key_exchanges"`
+	MACs              []string `json:"macs"`
+	HostKeyAlgorithms []string `json:"host_key_algorithms"`
+}
+
+// Resolve returns the policy with the lists of its preset filled in, and checks that every
+// algorithm is supported.
+func (p CryptoPolicy) Resolve() (CryptoPolicy, error) {
+	if p.Preset != "" {
+		preset, ok := cryptoPresets[p.Preset]
+		if !ok {
+			return p, fmt.Errorf("crypto policy: unknown preset %q", p.Preset)
+		}
+		if len(p.Ciphers) == 0 {
+			p.Ciphers = preset.Ciphers
+		}
+		if len(p.KeyExchanges) == 0 {
+			p.KeyExchanges = preset.KeyExchanges
+		}
+		if len(p.MACs) == 0 {
+			p.MACs = preset.MACs
+		}
+		if len(p.HostKeyAlgorithms) == 0 {
+			p.HostKeyAlgorithms = preset.HostKeyAlgorithms
+		}
+	}
+	err := errors.Join(
+		checkAlgorithms("cipher", p.Ciphers, supportedCiphers),
+		checkAlgorithms("key exchange", p.KeyExchanges, supportedKeyExchanges),
+		checkAlgorithms("MAC", p.MACs, supportedMACs),
+		checkAlgorithms("host key algorithm", p.HostKeyAlgorithms, supportedHostKeyAlgorithms),
+	)
+	return p, err
+}
+
+func checkAlgorithms(kind string, names, supported []string) error {
+	var errs []error
+	for _, name := range names {
+		if !slices.Contains(supported, name) {
+			errs = append(errs, fmt.Errorf("crypto policy: unsupported %s %q", kind, name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// apply restricts the server configuration to the algorithms of the resolved policy, and
+// returns the host keys usable with its host key algorithms.
+func (p CryptoPolicy) apply(config *ssh.ServerConfig, signers []ssh.Signer) ([]ssh.Signer, error) {
+	config.Ciphers = p.Ciphers
+	config.KeyExchanges = p.KeyExchanges
+	config.MACs = p.MACs
+	if len(p.HostKeyAlgorithms) == 0 {
+		return signers, nil
+	}
+
+	allowed := make([]ssh.Signer, 0, len(signers))
+	for _, signer := range signers {
+		algorithms := []string{signer.PublicKey().Type()}
+		if algorithms[0] == ssh.KeyAlgoRSA {
+			algorithms = []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+		}
+		algorithms = slices.DeleteFunc(algorithms, func(a string) bool {
+			return !slices.Contains(p.HostKeyAlgorithms, a)
+		})
+		if len(algorithms) == 0 {
+			continue
+		}
+		if as, ok := signer.(ssh.AlgorithmSigner); ok && signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+			restricted, err := ssh.NewSignerWithAlgorithms(as, algorithms)
+			if err != nil {
+				return nil, err
+			}
+			signer = restricted
+		}
+		allowed = append(allowed, signer)
+	}
+	if len(allowed) == 0 {
+		return nil, errors.New("crypto policy: none of the host keys can be used with the allowed host key algorithms")
+	}
+	return allowed, nil
+}
@@ -0,0 +1,359 @@
+package ftpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/oarkflow/ftp-server/log"
+)
+
+// ErrDispatcherQueueFull ... Returned when a notification could neither be queued nor spooled.
+var ErrDispatcherQueueFull = errors.New("notification queue is full")
+
+// DispatcherConfig ... Configures asynchronous delivery of notifications.
+type DispatcherConfig struct {
+	// QueueSize is the number of notifications that can wait for delivery in memory.
+	QueueSize int `json:"queue_size"`
+	// Workers is the number of notifications delivered concurrently.
+	Workers int `json:"workers"`
+	// MaxAttempts is the number of times delivery is attempted before giving up.
+	MaxAttempts int `json:"max_attempts"`
+	// Backoff is the delay before the first retry, it doubles on every further attempt
+	// up to MaxBackoff.
+	Backoff    time.Duration `json:"backoff"`
+	MaxBackoff time.Duration `json:"max_backoff"`
+	// SpoolPath is a directory notifications are persisted to until they are delivered, so
+	// that they survive a restart. Notifications that exhaust their attempts are moved to
+	// its "failed" subdirectory. Spooling is disabled when empty.
+	SpoolPath string `json:"spool_path"`
+	// ReplayInterval is how often the spool is scanned for notifications that did not fit
+	// in the queue.
+	ReplayInterval time.Duration `json:"replay_interval"`
+}
+
+func (c DispatcherConfig) withDefaults() DispatcherConfig {
+	if c.QueueSize <= 0 {
+		c.QueueSize = 1024
+	}
+	if c.Workers <= 0 {
+		c.Workers = 4
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = 5
+	}
+	if c.Backoff <= 0 {
+		c.Backoff = time.Second
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = time.Minute
+	}
+	if c.ReplayInterval <= 0 {
+		c.ReplayInterval = 30 * time.Second
+	}
+	return c
+}
+
+// JoinNotificationHandlers returns a handler calling every non-nil handler in turn, joining
+// the errors they return.
+func JoinNotificationHandlers(handlers ...NotificationHandler) NotificationHandler {
+	handlers = slices.DeleteFunc(slices.Clone(handlers), func(h NotificationHandler) bool {
+		return h == nil
+	})
+	if len(handlers) == 1 {
+		return handlers[0]
+	}
+	return func(n Notification) error {
+		var errs []error
+		for _, h := range handlers {
+			if err := h(n); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	}
+}
+
+// Dispatcher ... Delivers notifications to a handler from a pool of workers, so that a slow
+// or failing handler never holds up logins or transfers. Failed deliveries are retried with
+// an exponential back-off.
+type Dispatcher struct {
+	config   DispatcherConfig
+	handler  NotificationHandler
+	logger   log.Logger
+	queue    chan *dispatchItem
+	stop     chan struct{}
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	inflight map[string]struct{}
+	seq      atomic.Uint64
+	started  bool
+	closed   bool
+}
+
+type dispatchItem struct {
+	notification Notification
+	spoolFile    string
+}
+
+// NewDispatcher creates a dispatcher delivering to handler. It does not deliver anything
+// until Start is called, notifications dispatched before are queued.
+func NewDispatcher(handler NotificationHandler, config DispatcherConfig, logger log.Logger) *Dispatcher {
+	config = config.withDefaults()
+	return &Dispatcher{
+		config:   config,
+		handler:  handler,
+		logger:   logger,
+		queue:    make(chan *dispatchItem, config.QueueSize),
+		stop:     make(chan struct{}),
+		inflight: make(map[string]struct{}),
+	}
+}
+
+// Start replays any notifications left in the spool by a previous run and starts the workers.
+func (d *Dispatcher) Start() error {
+	d.mu.Lock()
+	if d.started || d.closed {
+		d.mu.Unlock()
+		return nil
+	}
+	d.started = true
+	d.mu.Unlock()
+
+	if d.config.SpoolPath != "" {
+		if err := os.MkdirAll(filepath.Join(d.config.SpoolPath, "failed"), 0755); err != nil {
+			return err
+		}
+		d.wg.Add(1)
+		go d.replayLoop()
+	}
+	for i := 0; i < d.config.Workers; i++ {
+		d.wg.Add(1)
+		go d.work()
+	}
+	return nil
+}
+
+// Dispatch queues the notification for delivery. It has the signature of a NotificationHandler
+// so it can be used in place of the handler it delivers to.
+func (d *Dispatcher) Dispatch(notification Notification) error {
+	item := &dispatchItem{notification: notification}
+	if d.config.SpoolPath != "" {
+		// Claim the file before it exists so the replay loop never queues it a second time.
+		item.spoolFile = fmt.Sprintf("%020d-%08d.json", time.Now().UnixNano(), d.seq.Add(1))
+		d.claim(item.spoolFile)
+		if err := d.spool(item.spoolFile, notification); err != nil {
+			d.logger.Error("could not spool notification", "event", notification.Event, "err", err)
+			d.release(item)
+			item.spoolFile = ""
+		}
+	}
+	if d.enqueue(item) {
+		return nil
+	}
+	if item.spoolFile != "" {
+		// It is safely on disk, the replay loop will pick it up once there is room.
+		d.release(item)
+		return nil
+	}
+	d.logger.Error("dropping notification, queue is full", "event", notification.Event)
+	return ErrDispatcherQueueFull
+}
+
+// Close stops the workers. Notifications that have not been delivered yet stay in the spool
+// and are delivered by the next dispatcher started on it.
+func (d *Dispatcher) Close() error {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closed = true
+	d.mu.Unlock()
+
+	close(d.stop)
+	d.wg.Wait()
+	if n := len(d.queue); n > 0 && d.config.SpoolPath == "" {
+		d.logger.Warn("undelivered notifications discarded on close", "count", n)
+	}
+	return nil
+}
+
+func (d *Dispatcher) enqueue(item *dispatchItem) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return false
+	}
+	select {
+	case d.queue <- item:
+		return true
+	default:
+		return false
+	}
+}
+
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case item := <-d.queue:
+			d.deliver(item)
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(item *dispatchItem) {
+	backoff := d.config.Backoff
+	for attempt := 1; ; attempt++ {
+		err := d.handler(item.notification)
+		if err == nil {
+			d.done(item, "")
+			return
+		}
+		if attempt >= d.config.MaxAttempts {
+			d.logger.Error("giving up on notification delivery",
+				"event", item.notification.Event,
+				"attempts", attempt,
+				"err", err,
+			)
+			d.done(item, "failed")
+			return
+		}
+		d.logger.Warn("notification delivery failed, retrying",
+			"event", item.notification.Event,
+			"attempt", attempt,
+			"retry_in", backoff.String(),
+			"err", err,
+		)
+		select {
+		case <-d.stop:
+			// Leave it in the spool for the next run.
+			d.release(item)
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, d.config.MaxBackoff)
+	}
+}
+
+// done removes a delivered notification from the spool, or moves it to the given subdirectory.
+func (d *Dispatcher) done(item *dispatchItem, moveTo string) {
+	defer d.release(item)
+	if item.spoolFile == "" {
+		return
+	}
+	p := filepath.Join(d.config.SpoolPath, item.spoolFile)
+	var err error
+	if moveTo == "" {
+		err = os.Remove(p)
+	} else {
+		err = os.Rename(p, filepath.Join(d.config.SpoolPath, moveTo, item.spoolFile))
+	}
+	if err != nil && !os.IsNotExist(err) {
+		d.logger.Error("could not update notification spool", "file", p, "err", err)
+	}
+}
+
+// claim marks a spooled notification as queued, returning false if it already was.
+func (d *Dispatcher) claim(name string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, exists := d.inflight[name]; exists {
+		return false
+	}
+	d.inflight[name] = struct{}{}
+	return true
+}
+
+func (d *Dispatcher) release(item *dispatchItem) {
+	if item.spoolFile == "" {
+		return
+	}
+	d.mu.Lock()
+	delete(d.inflight, item.spoolFile)
+	d.mu.Unlock()
+}
+
+// spool writes the notification to the named file in the spool directory.
+func (d *Dispatcher) spool(name string, notification Notification) error {
+	data, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(d.config.SpoolPath, 0755); err != nil {
+		return err
+	}
+	tmp := filepath.Join(d.config.SpoolPath, "."+name+".tmp")
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	// Renaming makes sure a replay never sees a partially written notification.
+	if err := os.Rename(tmp, filepath.Join(d.config.SpoolPath, name)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func (d *Dispatcher) replayLoop() {
+	defer d.wg.Done()
+	ticker := time.NewTicker(d.config.ReplayInterval)
+	defer ticker.Stop()
+	for {
+		d.replay()
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// replay queues spooled notifications that are not already waiting for delivery, oldest first.
+func (d *Dispatcher) replay() {
+	entries, err := os.ReadDir(d.config.SpoolPath)
+	if err != nil {
+		d.logger.Error("could not read notification spool", "path", d.config.SpoolPath, "err", err)
+		return
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !d.claim(name) {
+			continue
+		}
+		item := &dispatchItem{spoolFile: name}
+		data, err := os.ReadFile(filepath.Join(d.config.SpoolPath, name))
+		if err != nil {
+			d.release(item)
+			continue
+		}
+		if err := json.Unmarshal(data, &item.notification); err != nil {
+			d.logger.Error("discarding unreadable spooled notification", "file", name, "err", err)
+			d.done(item, "failed")
+			continue
+		}
+		if !d.enqueue(item) {
+			d.release(item)
+			return
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package ftpserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/oarkflow/ftp-server/log/oarklog"
+)
+
+const webhookSecret = "secret"
+
+// delivery ... A request the webhook received.
+type delivery struct {
+	key, signature string
+	body           []byte
+	at             time.Time
+	accepted       bool
+}
+
+// webhook ... A receiver that fails requests until it recovers.
+type webhook struct {
+	*httptest.Server
+	failing    atomic.Bool
+	mu         sync.Mutex
+	deliveries []delivery
+}
+
+func newWebhook(t *testing.T) *webhook {
+	w := &webhook{}
+	w.failing.Store(true)
+	w.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		d := delivery{
+			key:       r.Header.Get("Idempotency-Key"),
+			signature: r.Header.Get("X-Signature-256"),
+			body:      body,
+			at:        time.Now(),
+			accepted:  !w.failing.Load(),
+		}
+		w.mu.Lock()
+		w.deliveries = append(w.deliveries, d)
+		w.mu.Unlock()
+		if !d.accepted {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(w.Close)
+	return w
+}
+
+func (w *webhook) sink() NotificationHandler {
+	return NewWebhookSink(WebhookConfig{URLs: []string{w.URL}, Secret: webhookSecret})
+}
+
+func (w *webhook) received() []delivery {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]delivery(nil), w.deliveries...)
+}
+
+// waitFor waits for the webhook to have received n requests.
+func (w *webhook) waitFor(t *testing.T, n int) []delivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if received := w.received(); len(received) >= n {
+			return received
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("the webhook received %d requests, want %d", len(w.received()), n)
+	return nil
+}
+
+// expectSigned checks that every delivery carries the same notification, key and signature.
+func expectSigned(t *testing.T, deliveries []delivery) {
+	t.Helper()
+	mac := hmac.New(sha256.New, []byte(webhookSecret))
+	mac.Write(deliveries[0].body)
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	sum := sha256.Sum256(deliveries[0].body)
+	key := hex.EncodeToString(sum[:])
+	for i, d := range deliveries {
+		if d.key != key || d.signature != signature || string(d.body) != string(deliveries[0].body) {
+			t.Fatalf("delivery %d has key %q and signature %q for %s, want %q and %q for %s",
+				i, d.key, d.signature, d.body, key, signature, deliveries[0].body)
+		}
+	}
+}
+
+func notification(event string) Notification {
+	return Notification{Event: event, User: "alice", Subject: "/file.txt", Time: time.Unix(1700000000, 0).UTC()}
+}
+
+func TestDispatcherRetry(t *testing.T) {
+	w := newWebhook(t)
+	d := NewDispatcher(w.sink(), DispatcherConfig{
+		MaxAttempts: 5,
+		Backoff:     20 * time.Millisecond,
+		MaxBackoff:  30 * time.Millisecond,
+	}, oarklog.Default())
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	if err := d.Dispatch(notification(EventUploadCompleted)); err != nil {
+		t.Fatal(err)
+	}
+	w.waitFor(t, 2)
+	w.failing.Store(false)
+	deliveries := w.waitFor(t, 3)
+	expectSigned(t, deliveries)
+
+	// The delay doubles after every attempt, up to MaxBackoff.
+	for i, want := range []time.Duration{20 * time.Millisecond, 30 * time.Millisecond} {
+		if got := deliveries[i+1].at.Sub(deliveries[i].at); got < want {
+			t.Errorf("retry %d came after %s, want at least %s", i+1, got, want)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if received := w.received(); len(received) != 3 || !received[2].accepted {
+		t.Fatalf("the webhook received %d requests, want 3 with the last accepted", len(received))
+	}
+}
+
+func TestDispatcherGivesUp(t *testing.T) {
+	w := newWebhook(t)
+	spool := t.TempDir()
+	d := NewDispatcher(w.sink(), DispatcherConfig{
+		MaxAttempts:    2,
+		Backoff:        time.Millisecond,
+		SpoolPath:      spool,
+		ReplayInterval: 10 * time.Millisecond,
+	}, oarklog.Default())
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	if err := d.Dispatch(notification(EventUploadCompleted)); err != nil {
+		t.Fatal(err)
+	}
+	w.waitFor(t, 2)
+	// Notifications that exhausted their attempts are set aside rather than replayed.
+	time.Sleep(50 * time.Millisecond)
+	if n := len(w.received()); n != 2 {
+		t.Fatalf("the webhook received %d requests, want 2", n)
+	}
+	if failed := spooled(t, filepath.Join(spool, "failed")); len(failed) != 1 {
+		t.Fatalf("%d notifications failed, want 1", len(failed))
+	}
+	if left := spooled(t, spool); len(left) != 0 {
+		t.Fatalf("%d notifications left to replay, want none", len(left))
+	}
+}
+
+// spooled returns the notifications found in dir.
+func spooled(t *testing.T, dir string) []Notification {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var notifications []Notification
+	for _, m := range matches {
+		data, err := os.ReadFile(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var n Notification
+		if err := json.Unmarshal(data, &n); err != nil {
+			t.Fatal(err)
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications
+}
+
+// TestDispatcherReplay spools a notification the webhook fails to accept, and delivers it from
+// the spool once a new dispatcher starts after the webhook recovered.
+func TestDispatcherReplay(t *testing.T) {
+	w := newWebhook(t)
+	spool := t.TempDir()
+	config := DispatcherConfig{
+		MaxAttempts:    10,
+		Backoff:        time.Hour,
+		SpoolPath:      spool,
+		ReplayInterval: 10 * time.Millisecond,
+	}
+	d := NewDispatcher(w.sink(), config, oarklog.Default())
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	sent := notification(EventUploadCompleted)
+	if err := d.Dispatch(sent); err != nil {
+		t.Fatal(err)
+	}
+	w.waitFor(t, 1)
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if left := spooled(t, spool); len(left) != 1 || left[0].Event != sent.Event || left[0].Subject != sent.Subject {
+		t.Fatalf("the spool holds %v, want the notification", left)
+	}
+
+	w.failing.Store(false)
+	d = NewDispatcher(w.sink(), config, oarklog.Default())
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	deliveries := w.waitFor(t, 2)
+	// Give the replay loop a few more rounds to deliver it again.
+	time.Sleep(100 * time.Millisecond)
+	if received := w.received(); len(received) != 2 {
+		t.Fatalf("the webhook received %d requests, want the notification once more", len(received))
+	}
+	expectSigned(t, deliveries)
+	if !deliveries[1].accepted {
+		t.Fatal("the replayed notification was not accepted")
+	}
+	if left := spooled(t, spool); len(left) != 0 {
+		t.Fatalf("the spool holds %v after delivery", left)
+	}
+}
+
+func TestDispatcherQueueFull(t *testing.T) {
+	// A file in place of the spool directory makes spooling fail.
+	unwritable := filepath.Join(t.TempDir(), "spool")
+	if err := os.WriteFile(unwritable, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		spool string
+		// err is returned by the dispatch that finds the queue full.
+		err error
+	}{
+		{name: "without spool", err: ErrDispatcherQueueFull},
+		{name: "spool failing", spool: unwritable, err: ErrDispatcherQueueFull},
+		{name: "spool", spool: t.TempDir()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newWebhook(t)
+			w.failing.Store(false)
+			d := NewDispatcher(w.sink(), DispatcherConfig{
+				QueueSize:      1,
+				SpoolPath:      tt.spool,
+				ReplayInterval: 10 * time.Millisecond,
+			}, oarklog.Default())
+			defer d.Close()
+
+			// Nothing is delivered before Start, the first notification fills the queue.
+			if err := d.Dispatch(notification(EventUploadCompleted)); err != nil {
+				t.Fatal(err)
+			}
+			if err := d.Dispatch(notification(EventDownloadCompleted)); !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+
+			if tt.spool == unwritable {
+				if err := d.Start(); err == nil {
+					t.Fatal("started on a spool that cannot be written")
+				}
+				return
+			}
+			if err := d.Start(); err != nil {
+				t.Fatal(err)
+			}
+			want := 1
+			if tt.err == nil {
+				want = 2
+			}
+			w.waitFor(t, want)
+			time.Sleep(50 * time.Millisecond)
+			if n := len(w.received()); n != want {
+				t.Fatalf("the webhook received %d notifications, want %d", n, want)
+			}
+		})
+	}
+}
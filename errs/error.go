@@ -23,12 +23,20 @@ const (
 	//
 	// @see https://tools.ietf.org/id/draft-ietf-secsh-filexfer-13.txt
 	ErrSSHQuotaExceeded = FxError(15)
+
+	// ErrSSHLockConflict ...
+	// Returned when a file cannot be opened because another session is currently using it.
+	//
+	// @see https://tools.ietf.org/id/draft-ietf-secsh-filexfer-13.txt
+	ErrSSHLockConflict = FxError(17)
 )
 
 func (e FxError) Error() string {
 	switch e {
 	case ErrSSHQuotaExceeded:
 		return "Quota Exceeded"
+	case ErrSSHLockConflict:
+		return "Lock Conflict: the file is in use by another session"
 	default:
 		return "Failure"
 	}
@@ -1,42 +1,23 @@
 package main
 
 import (
-	"encoding/json"
 	"os"
 
-	"github.com/oarkflow/ftp-server"
-	"github.com/oarkflow/ftp-server/models"
+	"github.com/oarkflow/ftp-server/config"
 )
 
-type config struct {
-	Address  string `json:"address"`
-	Filepath string `json:"files"`
-	Port     int    `json:"port"`
-	ReadOnly bool   `json:"readOnly"`
-}
-
 func main() {
-	var users map[string]models.User
-	var conf config
-	configFile, err := os.ReadFile("config.json")
-	if err != nil {
-		panic(err)
+	path := "config.json"
+	if len(os.Args) > 1 {
+		path = os.Args[1]
 	}
-	err = json.Unmarshal(configFile, &conf)
+	conf, err := config.Load(path)
 	if err != nil {
 		panic(err)
 	}
-	usersFile, err := os.ReadFile("users.json")
+	server, err := conf.NewServer()
 	if err != nil {
 		panic(err)
 	}
-	err = json.Unmarshal(usersFile, &users)
-	if err != nil {
-		panic(err)
-	}
-	server := ftpserver.NewWithNotify()
-	for _, user := range users {
-		server.AddUser(user)
-	}
 	panic(server.Initialize())
 }
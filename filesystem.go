@@ -1,6 +1,7 @@
 package ftpserver
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
@@ -9,17 +10,30 @@ import (
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 
+	"github.com/oarkflow/ftp-server/audit"
 	"github.com/oarkflow/ftp-server/fs"
+	"github.com/oarkflow/ftp-server/fs/aferofs"
 	"github.com/oarkflow/ftp-server/fs/afos"
+	"github.com/oarkflow/ftp-server/fs/mem"
 	"github.com/oarkflow/ftp-server/fs/s3"
 	"github.com/oarkflow/ftp-server/log"
 	"github.com/oarkflow/ftp-server/models"
 	"github.com/oarkflow/ftp-server/providers"
+	"github.com/oarkflow/ftp-server/xferlog"
 )
 
 type FS struct {
 	fs       fs.FS
 	callback NotificationHandler
+	// server is set for the filesystems of a session, and runs the post-processing
+	// actions of completed uploads.
+	server *Server
+	// session carries the span of the SSH session, the parent of the request spans.
+	session context.Context
+	// userLimit holds the bandwidth limits of the session user, if any.
+	userLimit *bandwidth
+	// activity is touched by every request and transfer, for the idle timeout.
+	activity *activity
 }
 
 func NewFS(fs fs.FS, callback NotificationHandler) fs.FS {
@@ -34,6 +48,17 @@ func (f *FS) Context() map[string]string {
 	return f.fs.Context()
 }
 
+const (
+	// EventUploadCompleted ... Emitted when the handle of an upload is closed.
+	EventUploadCompleted = "UploadCompleted"
+	// EventDownloadCompleted ... Emitted when the handle of a download is closed once every
+	// byte of the file has been read.
+	EventDownloadCompleted = "DownloadCompleted"
+	// EventDownloadAborted ... Emitted instead of EventDownloadCompleted when the client closed
+	// the handle before reading the whole file.
+	EventDownloadAborted = "DownloadAborted"
+)
+
 type Notification struct {
 	User          string    `json:"user"`
 	FsType        string    `json:"fs_type"`
@@ -44,13 +69,51 @@ type Notification struct {
 	Subject       string    `json:"subject"`
 	Target        string    `json:"target"`
 	Error         error     `json:"error"`
+	// Bytes, Duration and Checksum are only set for completed transfers. Checksum is the hex
+	// encoded SHA-256 of the content, and is left empty when it could not be computed while
+	// streaming, e.g. for partial or out of order transfers.
+	Bytes    int64         `json:"bytes,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+	Checksum string        `json:"checksum,omitempty"`
+	// Action is the name of the post-processing action an ActionCompleted or ActionFailed
+	// notification is about.
+	Action string `json:"action,omitempty"`
 }
 
-func (f *FS) Notify(request *sftp.Request, err error) {
-	method := request.Method
-	if method == "List" {
-		return
+// MarshalJSON encodes the notification, with its error as a message rather than the empty
+// object an error value marshals to.
+func (n Notification) MarshalJSON() ([]byte, error) {
+	type alias Notification
+	var msg string
+	if n.Error != nil {
+		msg = n.Error.Error()
+	}
+	return json.Marshal(struct {
+		alias
+		Error string `json:"error,omitempty"`
+	}{alias: alias(n), Error: msg})
+}
+
+// UnmarshalJSON decodes a notification encoded by MarshalJSON.
+func (n *Notification) UnmarshalJSON(data []byte) error {
+	type alias Notification
+	aux := struct {
+		*alias
+		Error string `json:"error,omitempty"`
+	}{alias: (*alias)(n)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	n.Error = nil
+	if aux.Error != "" {
+		n.Error = errors.New(aux.Error)
 	}
+	return nil
+}
+
+// newNotification prepares a notification about the request carrying the session details,
+// along with the matching key values for the logger.
+func (f *FS) newNotification(event string, request *sftp.Request) (Notification, []any) {
 	notification := Notification{Time: time.Now().UTC(), FsType: f.Type()}
 	keyvals := []any{"fs_type", f.Type()}
 	for key, val := range f.fs.Context() {
@@ -65,13 +128,22 @@ func (f *FS) Notify(request *sftp.Request, err error) {
 		}
 		keyvals = append(keyvals, key, val)
 	}
-	notification.Event = method
+	notification.Event = event
 	notification.Subject = request.Filepath
-	keyvals = append(keyvals, "event", method, "subject", request.Filepath)
+	keyvals = append(keyvals, "event", event, "subject", request.Filepath)
 	notification.Target = request.Target
 	if request.Target != "" {
 		keyvals = append(keyvals, "target", request.Target)
 	}
+	return notification, keyvals
+}
+
+func (f *FS) Notify(request *sftp.Request, err error) {
+	method := request.Method
+	if method == "List" {
+		return
+	}
+	notification, keyvals := f.newNotification(method, request)
 	if err != nil && !errors.Is(err, sftp.ErrSshFxOk) {
 		keyvals = append(keyvals, "error", err)
 		notification.Error = err
@@ -84,31 +156,154 @@ func (f *FS) Notify(request *sftp.Request, err error) {
 	}
 }
 
+// audit records the operation in the audit log of the server, if there is one.
+func (f *FS) audit(operation string, request *sftp.Request, err error, started time.Time, bytes int64) {
+	if f.server == nil || f.server.auditLog == nil {
+		return
+	}
+	ctx := f.fs.Context()
+	record := audit.Record{
+		Time:      time.Now(),
+		SessionID: ctx["session_id"],
+		User:      ctx["user"],
+		IP:        ctx["remote_addr"],
+		FsType:    f.Type(),
+		Operation: operation,
+		Path:      request.Filepath,
+		Target:    request.Target,
+		Bytes:     bytes,
+		Duration:  float64(time.Since(started).Microseconds()) / 1000,
+	}
+	record.Code, record.Result = audit.Status(err)
+	if record.Code != 0 {
+		record.Error = err.Error()
+	}
+	f.server.audit(record)
+}
+
+// metrics returns the metrics of the server, nil when they are not collected.
+func (f *FS) metrics() *serverMetrics {
+	if f.server == nil {
+		return nil
+	}
+	return f.server.metrics
+}
+
+// NotifyTransfer reports a finished upload or download once its handle has been closed.
+func (f *FS) NotifyTransfer(t *transfer, err error) {
+	f.audit(t.event, t.request, err, t.started, t.bytes.Load())
+	notification, keyvals := f.newNotification(t.event, t.request)
+	notification.Bytes = t.bytes.Load()
+	notification.Duration = time.Since(t.started)
+	// The checksum of part of a download would not match the file.
+	if t.event != EventDownloadAborted {
+		notification.Checksum = t.checksum.sum()
+	}
+	keyvals = append(keyvals,
+		"bytes", notification.Bytes,
+		"duration", notification.Duration.String(),
+		"checksum", notification.Checksum,
+	)
+	if err != nil {
+		keyvals = append(keyvals, "error", err)
+		notification.Error = err
+		f.fs.Logger().Error("SFTP Transfer Completed", keyvals...)
+	} else {
+		f.fs.Logger().Info("SFTP Transfer Completed", keyvals...)
+	}
+	if t.event == EventUploadCompleted {
+		f.metrics().transferred(f.Type(), "upload", notification.Bytes)
+	} else {
+		f.metrics().transferred(f.Type(), "download", notification.Bytes)
+	}
+	if f.server != nil {
+		direction := xferlog.Outgoing
+		if t.event == EventUploadCompleted {
+			direction = xferlog.Incoming
+		}
+		f.server.transferred(xferlog.Entry{
+			Time:       notification.Time,
+			Duration:   notification.Duration,
+			RemoteHost: notification.RemoteAddr,
+			Bytes:      notification.Bytes,
+			Filename:   notification.Subject,
+			Direction:  direction,
+			User:       notification.User,
+			Complete:   err == nil && t.event != EventDownloadAborted,
+		})
+	}
+	if f.callback != nil {
+		f.callback(notification)
+	}
+	if err == nil && t.event == EventUploadCompleted && f.server != nil && len(f.server.actions) > 0 {
+		go f.server.runActions(f.fs, notification)
+	}
+}
+
 func (f *FS) Fileread(request *sftp.Request) (io.ReaderAt, error) {
 	var err error
+	started := time.Now()
+	f.activity.touch()
+	request, span := f.startSpan(request)
 	defer func() {
+		f.audit(request.Method, request, err, started, 0)
+		f.metrics().operation(request.Method, err, started)
 		f.Notify(request, err)
 	}()
 	rs, e := f.fs.Fileread(request)
 	err = e
-	return rs, e
+	if e != nil {
+		endSpan(span, e)
+		return rs, e
+	}
+	return &readTransfer{
+		transfer: f.newTransfer(EventDownloadCompleted, request, rs, span),
+		reader:   f.throttleReader(request.Context(), rs),
+	}, nil
 }
 
 func (f *FS) Filewrite(request *sftp.Request) (io.WriterAt, error) {
 	var err error
+	started := time.Now()
+	f.activity.touch()
+	request, span := f.startSpan(request)
 	defer func() {
+		f.audit(request.Method, request, err, started, 0)
+		f.metrics().operation(request.Method, err, started)
 		f.Notify(request, err)
 	}()
+	if f.server.ReadOnly() {
+		err = sftp.ErrSshFxPermissionDenied
+		endSpan(span, err)
+		return nil, err
+	}
 	rs, e := f.fs.Filewrite(request)
 	err = e
-	return rs, e
+	if e != nil {
+		endSpan(span, e)
+		return rs, e
+	}
+	return &writeTransfer{
+		transfer: f.newTransfer(EventUploadCompleted, request, rs, span),
+		writer:   f.throttleWriter(request.Context(), rs),
+	}, nil
 }
 
 func (f *FS) Filecmd(request *sftp.Request) error {
 	var err error
+	started := time.Now()
+	f.activity.touch()
+	request, span := f.startSpan(request)
 	defer func() {
+		endSpan(span, err)
+		f.audit(request.Method, request, err, started, 0)
+		f.metrics().operation(request.Method, err, started)
 		f.Notify(request, err)
 	}()
+	if f.server.ReadOnly() {
+		err = sftp.ErrSshFxPermissionDenied
+		return err
+	}
 	e := f.fs.Filecmd(request)
 	err = e
 	return e
@@ -116,7 +311,13 @@ func (f *FS) Filecmd(request *sftp.Request) error {
 
 func (f *FS) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
 	var err error
+	started := time.Now()
+	f.activity.touch()
+	request, span := f.startSpan(request)
 	defer func() {
+		endSpan(span, err)
+		f.audit(request.Method, request, err, started, 0)
+		f.metrics().operation(request.Method, err, started)
 		f.Notify(request, err)
 	}()
 	rs, e := f.fs.Filelist(request)
@@ -140,6 +341,15 @@ func (f *FS) Permissions() []string {
 	return f.fs.Permissions()
 }
 
+func (f *FS) SetReadOnly(readOnly bool) {
+	f.fs.SetReadOnly(readOnly)
+}
+
+// ReadOnly reports whether the filesystem, or the whole server, is read-only.
+func (f *FS) ReadOnly() bool {
+	return f.fs.ReadOnly() || f.server.ReadOnly()
+}
+
 func (f *FS) SetConn(sconn *ssh.ServerConn) {
 	f.fs.SetConn(sconn)
 }
@@ -159,19 +369,20 @@ func (f *FS) Type() string {
 func (c *Server) getUserFilesystem(sconn *ssh.ServerConn, path string) (fs.FS, error) {
 	var userFS models.Filesystem
 	if useDefaultFS, exists := sconn.Permissions.Extensions["default_fs"]; exists && useDefaultFS == "true" {
-		fst := afos.New(path)
-		fst.SetLogger(c.logger)
-		fst.SetPermissions(providers.DefaultPermissions)
-		return fst, nil
+		return c.newAfos(path, providers.DefaultPermissions), nil
 	}
 
 	err := json.Unmarshal([]byte(sconn.Permissions.Extensions["filesystem"]), &userFS)
 	if err != nil {
-		fst := afos.New(path)
-		fst.SetLogger(c.logger)
-		fst.SetPermissions(providers.DefaultPermissions)
-		return fst, nil
+		return c.newAfos(path, providers.DefaultPermissions), nil
 	}
+	return c.newFilesystem(userFS, path, sconn.Permissions.Extensions["user"])
+}
+
+// newFilesystem creates the backend described by userFS for user, or by the storage registered
+// with aferofs under its type, falling back to an OS backed filesystem rooted at path for
+// unknown types.
+func (c *Server) newFilesystem(userFS models.Filesystem, path, user string) (fs.FS, error) {
 	permissions := userFS.Permissions
 	if len(userFS.Permissions) == 0 {
 		permissions = providers.DefaultPermissions
@@ -203,25 +414,71 @@ func (c *Server) getUserFilesystem(sconn *ssh.ServerConn, path string) (fs.FS, e
 			AccessKey: accessKey,
 			Secret:    secret,
 		}
+		if c.metrics != nil {
+			opt.Observer = c.metrics.observeS3
+		}
+		if c.tracerProvider != nil {
+			opt.Tracer = c.tracer
+		}
 		fst, err := s3.New(opt)
 		if err != nil {
 			return nil, err
 		}
 		fst.SetLogger(c.logger)
 		fst.SetPermissions(permissions)
+		fst.SetReadOnly(userFS.ReadOnly)
 		return fst, nil
 	case "os":
 		basePath := ""
 		if val, exists := userFS.Params["base_path"]; exists {
 			basePath = val.(string)
 		}
-		fst := afos.New(basePath)
+		var opts []func(*afos.Afos)
+		if val, exists := userFS.Params["atomic_uploads"].(bool); exists {
+			opts = append(opts, afos.WithAtomicUploads(val))
+		}
+		opts = append(opts, afos.WithReadOnly(userFS.ReadOnly))
+		return c.newAfos(basePath, permissions, opts...), nil
+	case "memory":
+		// Every user gets a store of their own, filesystems naming the same store share their
+		// files instead. Either lasts for as long as the server runs.
+		name := "user:" + user
+		var maxSize int64
+		if val, exists := userFS.Params["store"].(string); exists && val != "" {
+			name = "shared:" + val
+		}
+		if val, exists := userFS.Params["max_size"].(float64); exists {
+			maxSize = int64(val)
+		}
+		store := c.memStores.Get(name, maxSize)
+		if store.MaxSize() != maxSize {
+			c.logger.Warn("memory store already exists with a different max_size, keeping it",
+				"store", name,
+				"max_size", store.MaxSize(),
+				"requested", maxSize,
+			)
+		}
+		fst := mem.New(store, mem.WithPermissions(permissions), mem.WithReadOnly(userFS.ReadOnly))
 		fst.SetLogger(c.logger)
-		fst.SetPermissions(permissions)
 		return fst, nil
 	}
-	fst := afos.New(path)
+	if _, registered := aferofs.Lookup(userFS.Fs); registered {
+		fst, err := aferofs.Open(userFS.Fs, userFS.Params, aferofs.WithPermissions(permissions), aferofs.WithReadOnly(userFS.ReadOnly))
+		if err != nil {
+			return nil, err
+		}
+		fst.SetLogger(c.logger)
+		return fst, nil
+	}
+	return c.newAfos(path, providers.DefaultPermissions), nil
+}
+
+// newAfos creates an OS backed filesystem sharing the server-wide lock manager, so that
+// sessions working on the same directory see each other's open files.
+func (c *Server) newAfos(basePath string, permissions []string, opts ...func(*afos.Afos)) fs.FS {
+	opts = append([]func(*afos.Afos){afos.WithLockManager(c.locks)}, opts...)
+	fst := afos.New(basePath, opts...)
 	fst.SetLogger(c.logger)
-	fst.SetPermissions(providers.DefaultPermissions)
-	return fst, nil
+	fst.SetPermissions(permissions)
+	return fst
 }
@@ -0,0 +1,65 @@
+package ftpserver
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/oarkflow/ftp-server/fs/afos"
+	"github.com/oarkflow/ftp-server/fs/fstest"
+	"github.com/oarkflow/ftp-server/log/oarklog"
+)
+
+// TestNewFS drives a filesystem created without a server, as embedders of the package do.
+func TestNewFS(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "data"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	backend := afos.New(dir, afos.WithPermissions(fstest.AllPermissions))
+	backend.SetLogger(oarklog.Default())
+	var mu sync.Mutex
+	var events []string
+	fsys := NewFS(backend, func(n Notification) error {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, n.Event)
+		return nil
+	})
+
+	if fsys.ReadOnly() {
+		t.Fatal("filesystem without a server is read-only")
+	}
+	if err := fstest.WriteFile(fsys, "/file.txt", []byte(fstest.Content)); err != nil {
+		t.Fatal(err)
+	}
+	for _, cmd := range []struct{ method, p, target string }{
+		{"Mkdir", "/dir", ""},
+		{"Rename", "/file.txt", "/dir/file.txt"},
+		{"Setstat", "/dir/file.txt", ""},
+	} {
+		if err := fstest.Cmd(fsys, cmd.method, cmd.p, cmd.target); !fstest.Succeeded(err) {
+			t.Fatalf("%s %s: %v", cmd.method, cmd.p, err)
+		}
+	}
+	data, err := fstest.ReadFile(fsys, "/dir/file.txt")
+	if err != nil || string(data) != fstest.Content {
+		t.Fatalf("read %q, %v, want %q", data, err, fstest.Content)
+	}
+	if err := fstest.Cmd(fsys, "Remove", "/dir/file.txt", ""); !fstest.Succeeded(err) {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, want := range []string{EventUploadCompleted, EventDownloadCompleted} {
+		found := false
+		for _, event := range events {
+			found = found || event == want
+		}
+		if !found {
+			t.Errorf("no %s notification among %v", want, events)
+		}
+	}
+}
@@ -0,0 +1,379 @@
+// Package aferofs exposes any afero.Fs to SFTP clients, applying the same permissions as the OS
+// backed filesystem. Storages registered with Register can be selected by users' filesystems.
+package aferofs
+
+import (
+	"io"
+	"os"
+	"path"
+	"slices"
+	"sync"
+
+	"github.com/pkg/sftp"
+	"github.com/spf13/afero"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/oarkflow/ftp-server/log"
+
+	"github.com/oarkflow/ftp-server/fs"
+	"github.com/oarkflow/ftp-server/fs/afos"
+)
+
+// Fs ... A filesystem exposed to a user, whose files live in an afero.Fs. Paths are resolved
+// beneath the root of the afero.Fs.
+type Fs struct {
+	fs          afero.Fs
+	fsType      string
+	logger      log.Logger
+	locks       *afos.LockManager
+	id          string
+	permissions int64
+	ctx         map[string]string
+	readOnly    bool
+	sconn       *ssh.ServerConn
+}
+
+// New exposes fsys as a filesystem of type "afero", unless WithType says otherwise.
+func New(fsys afero.Fs, opts ...func(*Fs)) fs.FS {
+	f := &Fs{
+		fs:     fsys,
+		fsType: "afero",
+		locks:  afos.NewLockManager(),
+	}
+	for _, o := range opts {
+		o(f)
+	}
+	return f
+}
+
+// file ... An open file handed over to the SFTP server. The lock taken on the path is held
+// for as long as the client keeps the handle open.
+type file struct {
+	// afero files are not all safe for concurrent use, the memory ones track a single
+	// offset for instance, so reads and writes at an offset are serialized.
+	mu      sync.Mutex
+	file    afero.File
+	release func()
+}
+
+func (f *file) ReadAt(buffer []byte, offset int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.ReadAt(buffer, offset)
+}
+
+func (f *file) WriteAt(buffer []byte, offset int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.WriteAt(buffer, offset)
+}
+
+// Stat describes the file, it lets the server tell whether a download read all of it.
+func (f *file) Stat() (os.FileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Stat()
+}
+
+// Close closes the file and releases the lock held on its path.
+func (f *file) Close() error {
+	defer f.release()
+	return f.file.Close()
+}
+
+// cleanPath resolves p as if the root of the storage was "/", so that ".." can never climb
+// above it.
+func cleanPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+// Fileread creates a reader for a file of the storage.
+func (f *Fs) Fileread(request *sftp.Request) (io.ReaderAt, error) {
+	if !fs.Can(f.permissions, fs.ReadContent) {
+		return nil, sftp.ErrSshFxPermissionDenied
+	}
+
+	p := cleanPath(request.Filepath)
+	stat, err := f.fs.Stat(p)
+	if os.IsNotExist(err) {
+		return nil, sftp.ErrSshFxNoSuchFile
+	} else if err != nil {
+		f.logger.Error("error performing file stat", "source", p, "err", err)
+		return nil, sftp.ErrSshFxFailure
+	}
+	if stat.IsDir() {
+		return nil, sftp.ErrSshFxOpUnsupported
+	}
+
+	// Any number of sessions may read the file at once, but not while it is being written.
+	release, err := f.locks.TryRLock(p)
+	if err != nil {
+		return nil, err
+	}
+
+	fl, err := f.fs.Open(p)
+	if err != nil {
+		release()
+		f.logger.Error("could not open file for reading", "source", p, "err", err)
+		return nil, sftp.ErrSshFxFailure
+	}
+
+	return &file{file: fl, release: release}, nil
+}
+
+// Filewrite creates or truncates a file of the storage, checking the create and update
+// permissions depending on whether the file already exists.
+func (f *Fs) Filewrite(request *sftp.Request) (io.WriterAt, error) {
+	if f.readOnly {
+		return nil, sftp.ErrSshFxPermissionDenied
+	}
+
+	p := cleanPath(request.Filepath)
+	stat, statErr := f.fs.Stat(p)
+	switch {
+	case os.IsNotExist(statErr):
+		if !fs.Can(f.permissions, fs.Create) {
+			return nil, sftp.ErrSshFxPermissionDenied
+		}
+	case statErr != nil:
+		f.logger.Error("error performing file stat", "source", p, "err", statErr)
+		return nil, sftp.ErrSshFxFailure
+	case !fs.Can(f.permissions, fs.Update):
+		return nil, sftp.ErrSshFxPermissionDenied
+	case stat.IsDir():
+		f.logger.Warn("attempted to open a directory for writing to", "source", p)
+		return nil, sftp.ErrSshFxOpUnsupported
+	}
+
+	// Hold the file exclusively until the client closes the handle so that no other
+	// session can read a partial upload or write to the same file at the same time.
+	release, err := f.locks.TryLock(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := f.fs.MkdirAll(path.Dir(p), 0755); err != nil {
+		release()
+		f.logger.Error("error making path for file", "source", p, "path", path.Dir(p), "err", err)
+		return nil, sftp.ErrSshFxFailure
+	}
+	fl, err := f.fs.OpenFile(p, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		release()
+		f.logger.Error("error creating file", "source", p, "err", err)
+		return nil, sftp.ErrSshFxFailure
+	}
+
+	return &file{file: fl, release: release}, nil
+}
+
+// Filecmd handles the SFTP calls changing files, other than reading and writing them.
+func (f *Fs) Filecmd(request *sftp.Request) error {
+	if f.readOnly {
+		return sftp.ErrSshFxPermissionDenied
+	}
+
+	p := cleanPath(request.Filepath)
+	var target string
+	if request.Target != "" {
+		target = cleanPath(request.Target)
+	}
+
+	switch request.Method {
+	case "Rename", "Remove":
+		// Renaming or removing a file that is in the middle of a transfer would pull it out
+		// from under the session that has it open.
+		release, err := f.lockPaths(p, target)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+
+	switch request.Method {
+	case "Setstat":
+		if !fs.Can(f.permissions, fs.Update) {
+			return sftp.ErrSshFxPermissionDenied
+		}
+
+		var mode os.FileMode = 0644
+		// If the client passed a valid file permission use that, otherwise use the
+		// default of 0644 set above.
+		if request.Attributes().FileMode().Perm() != 0000 {
+			mode = request.Attributes().FileMode().Perm()
+		}
+
+		// Force directories to be 0755
+		if request.Attributes().FileMode().IsDir() {
+			mode = 0755
+		}
+
+		if err := f.fs.Chmod(p, mode); err != nil {
+			f.logger.Error("failed to perform setstat", "err", err)
+			return sftp.ErrSshFxFailure
+		}
+		return nil
+	case "Rename":
+		if !fs.Can(f.permissions, fs.Update) {
+			return sftp.ErrSshFxPermissionDenied
+		}
+
+		if err := f.fs.Rename(p, target); err != nil {
+			f.logger.Error("failed to rename file",
+				"source", p,
+				"target", target,
+				"err", err,
+			)
+			return sftp.ErrSshFxFailure
+		}
+	case "Rmdir":
+		if !fs.Can(f.permissions, fs.Delete) {
+			return sftp.ErrSshFxPermissionDenied
+		}
+
+		if err := f.fs.RemoveAll(p); err != nil {
+			f.logger.Error("failed to remove directory", "source", p, "err", err)
+			return sftp.ErrSshFxFailure
+		}
+	case "Mkdir":
+		if !fs.Can(f.permissions, fs.Create) {
+			return sftp.ErrSshFxPermissionDenied
+		}
+
+		if err := f.fs.MkdirAll(p, 0755); err != nil {
+			f.logger.Error("failed to create directory", "source", p, "err", err)
+			return sftp.ErrSshFxFailure
+		}
+	case "Symlink":
+		if !fs.Can(f.permissions, fs.Create) {
+			return sftp.ErrSshFxPermissionDenied
+		}
+
+		// Links are not supported, for the same reasons readlink is not: where they point to
+		// is up to the storage, and could lie outside of what the user may access.
+		return sftp.ErrSshFxOpUnsupported
+	case "Remove":
+		if !fs.Can(f.permissions, fs.Delete) {
+			return sftp.ErrSshFxPermissionDenied
+		}
+
+		if err := f.fs.Remove(p); err != nil {
+			if !os.IsNotExist(err) {
+				f.logger.Error("failed to remove a file", "source", p, "err", err)
+			}
+			return sftp.ErrSshFxFailure
+		}
+	default:
+		return sftp.ErrSshFxOpUnsupported
+	}
+
+	return sftp.ErrSshFxOk
+}
+
+// lockPaths takes an exclusive lock on every non-empty path given, releasing any lock that was
+// already taken if one of them is in use.
+func (f *Fs) lockPaths(paths ...string) (func(), error) {
+	var releases []func()
+	release := func() {
+		for _, r := range releases {
+			r()
+		}
+	}
+	for i, p := range paths {
+		if p == "" || slices.Contains(paths[:i], p) {
+			continue
+		}
+		r, err := f.locks.TryLock(p)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		releases = append(releases, r)
+	}
+	return release, nil
+}
+
+// Filelist handles listing directories and stat calls.
+func (f *Fs) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
+	p := cleanPath(request.Filepath)
+
+	switch request.Method {
+	case "List":
+		if !fs.Can(f.permissions, fs.Read) {
+			return nil, sftp.ErrSshFxPermissionDenied
+		}
+
+		files, err := afero.ReadDir(f.fs, p)
+		if os.IsNotExist(err) {
+			return nil, sftp.ErrSshFxNoSuchFile
+		} else if err != nil {
+			f.logger.Error("error listing directory", "err", err)
+			return nil, sftp.ErrSshFxFailure
+		}
+		return fs.ListerAt(files), nil
+	case "Stat":
+		if !fs.Can(f.permissions, fs.Read) {
+			return nil, sftp.ErrSshFxPermissionDenied
+		}
+
+		s, err := f.fs.Stat(p)
+		if os.IsNotExist(err) {
+			return nil, sftp.ErrSshFxNoSuchFile
+		} else if err != nil {
+			f.logger.Error("error running STAT on file", "err", err)
+			return nil, sftp.ErrSshFxFailure
+		}
+
+		return fs.ListerAt([]os.FileInfo{s}), nil
+	default:
+		return nil, sftp.ErrSshFxOpUnsupported
+	}
+}
+
+func (f *Fs) SetLogger(logger log.Logger) {
+	f.logger = logger
+}
+
+func (f *Fs) Logger() log.Logger {
+	return f.logger
+}
+
+func (f *Fs) SetPermissions(p []string) {
+	f.permissions = fs.Serialize(p)
+}
+
+func (f *Fs) Permissions() []string {
+	return fs.Deserialize(f.permissions)
+}
+
+func (f *Fs) SetReadOnly(readOnly bool) {
+	f.readOnly = readOnly
+}
+
+func (f *Fs) ReadOnly() bool {
+	return f.readOnly
+}
+
+func (f *Fs) SetContext(ctx map[string]string) {
+	f.ctx = ctx
+}
+
+func (f *Fs) Context() map[string]string {
+	return f.ctx
+}
+
+func (f *Fs) SetConn(sconn *ssh.ServerConn) {
+	f.sconn = sconn
+}
+
+func (f *Fs) Conn() *ssh.ServerConn {
+	return f.sconn
+}
+
+func (f *Fs) SetID(p string) {
+	f.id = p
+}
+
+func (f *Fs) Type() string {
+	return f.fsType
+}
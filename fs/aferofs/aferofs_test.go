@@ -0,0 +1,123 @@
+package aferofs_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"github.com/oarkflow/ftp-server/errs"
+	"github.com/oarkflow/ftp-server/fs"
+	"github.com/oarkflow/ftp-server/fs/aferofs"
+	"github.com/oarkflow/ftp-server/fs/fstest"
+	"github.com/oarkflow/ftp-server/log/oarklog"
+)
+
+// factory exposes the storage newStorage creates for each test.
+func factory(newStorage func(t *testing.T) afero.Fs) fstest.Factory {
+	return func(t *testing.T) func(permissions []string) fs.FS {
+		storage := newStorage(t)
+		return func(permissions []string) fs.FS {
+			fsys := aferofs.New(storage, aferofs.WithPermissions(permissions))
+			fsys.SetLogger(oarklog.Default())
+			return fsys
+		}
+	}
+}
+
+func TestMemMapFs(t *testing.T) {
+	fstest.Run(t, factory(func(t *testing.T) afero.Fs {
+		return afero.NewMemMapFs()
+	}))
+}
+
+func TestBasePathFs(t *testing.T) {
+	fstest.Run(t, factory(func(t *testing.T) afero.Fs {
+		return afero.NewBasePathFs(afero.NewOsFs(), t.TempDir())
+	}))
+}
+
+// registrations makes the names TestRegister registers unique, the registry outlives a run of
+// the test.
+var registrations atomic.Int64
+
+func TestRegister(t *testing.T) {
+	name := fmt.Sprintf("test-shared-%d", registrations.Add(1))
+	storages := map[string]afero.Fs{}
+	aferofs.Register(name, aferofs.Backend{
+		Params: []string{"name"},
+		Open: func(params map[string]any) (afero.Fs, error) {
+			storage, _ := params["name"].(string)
+			if storage == "" {
+				return nil, errors.New("name is required")
+			}
+			if storages[storage] == nil {
+				storages[storage] = afero.NewMemMapFs()
+			}
+			return storages[storage], nil
+		},
+	})
+
+	if _, registered := aferofs.Lookup(name); !registered {
+		t.Fatal("registered backend not found")
+	}
+	if _, err := aferofs.Open(name, nil); err == nil {
+		t.Fatal("opened a backend with invalid parameters")
+	}
+	if _, err := aferofs.Open("test-missing", nil); err == nil {
+		t.Fatal("opened a backend that is not registered")
+	}
+
+	params := map[string]any{"name": "drop"}
+	first, err := aferofs.Open(name, params, aferofs.WithPermissions(fstest.AllPermissions))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := aferofs.Open(name, params, aferofs.WithPermissions(fstest.AllPermissions))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.SetLogger(oarklog.Default())
+	second.SetLogger(oarklog.Default())
+	if first.Type() != name {
+		t.Fatalf("type is %q, want %s", first.Type(), name)
+	}
+	if err := fstest.WriteFile(first, "/file.txt", []byte(fstest.Content)); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := fstest.ReadFile(second, "/file.txt"); err != nil || string(data) != fstest.Content {
+		t.Fatalf("reading from the same storage: %q, %v", data, err)
+	}
+
+	// An upload in progress holds its path on the storage it writes to, and only that one.
+	w, err := first.Filewrite(fstest.Request("Put", "/busy.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.(io.Closer).Close()
+	if _, err := second.Filewrite(fstest.Request("Put", "/busy.txt")); !errors.Is(err, errs.ErrSSHLockConflict) {
+		t.Fatalf("writing to the same storage: got %v, want %v", err, errs.ErrSSHLockConflict)
+	}
+	other, err := aferofs.Open(name, map[string]any{"name": "other"}, aferofs.WithPermissions(fstest.AllPermissions))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.SetLogger(oarklog.Default())
+	if err := fstest.WriteFile(other, "/busy.txt", []byte(fstest.Content)); err != nil {
+		t.Fatalf("writing to another storage: %v", err)
+	}
+
+	for _, taken := range []string{name, "os"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("registering %s again did not panic", taken)
+				}
+			}()
+			aferofs.Register(taken, aferofs.Backend{Open: func(map[string]any) (afero.Fs, error) { return nil, nil }})
+		}()
+	}
+}
@@ -0,0 +1,33 @@
+package aferofs
+
+import (
+	"github.com/oarkflow/ftp-server/fs"
+	"github.com/oarkflow/ftp-server/fs/afos"
+)
+
+// WithType sets the type the filesystem reports, the name it is registered with.
+func WithType(val string) func(f *Fs) {
+	return func(o *Fs) {
+		o.fsType = val
+	}
+}
+
+func WithPermissions(val []string) func(f *Fs) {
+	return func(o *Fs) {
+		o.permissions = fs.Serialize(val)
+	}
+}
+
+func WithReadOnly(val bool) func(f *Fs) {
+	return func(o *Fs) {
+		o.readOnly = val
+	}
+}
+
+// WithLockManager shares a lock manager between filesystems on the same storage, so that
+// concurrent transfers of the same file are detected across sessions.
+func WithLockManager(val *afos.LockManager) func(f *Fs) {
+	return func(o *Fs) {
+		o.locks = val
+	}
+}
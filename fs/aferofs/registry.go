@@ -0,0 +1,120 @@
+package aferofs
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/spf13/afero"
+
+	"github.com/oarkflow/ftp-server/fs"
+	"github.com/oarkflow/ftp-server/fs/afos"
+)
+
+// Backend ... An afero-compatible storage that users' filesystems can be backed by, selected
+// by the name it is registered with as the "fs" of a models.Filesystem.
+type Backend struct {
+	// Params lists the parameters the storage accepts, any other one is reported when the
+	// configuration is validated.
+	Params []string
+	// Open creates the storage of a filesystem from its parameters. It is called for every
+	// session, and may return the same afero.Fs for the same parameters. Filesystems opened
+	// with equal parameters are taken to be on the same storage and share their locks.
+	Open func(params map[string]any) (afero.Fs, error)
+}
+
+// builtin ... The filesystem types the server implements itself, which cannot be registered.
+var builtin = []string{"os", "s3", "memory"}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]*registered)
+)
+
+type registered struct {
+	backend Backend
+	mu      sync.Mutex
+	// locks holds a lock manager for each set of parameters the backend was opened with,
+	// shared by the filesystems opened on the same storage.
+	locks map[string]*afos.LockManager
+}
+
+// lockManager returns the lock manager of the storage the backend opens with params.
+func (r *registered) lockManager(params map[string]any) *afos.LockManager {
+	if len(params) == 0 {
+		params = nil
+	}
+	// Encoding sorts the keys, and numbers decoded from the configuration as int or float64
+	// end up the same, so that equal parameters lead to the same key.
+	key, err := json.Marshal(params)
+	if err != nil {
+		// Without knowing which storage the parameters identify, the filesystem cannot
+		// share its locks with any other.
+		return afos.NewLockManager()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	locks, exists := r.locks[string(key)]
+	if !exists {
+		locks = afos.NewLockManager()
+		r.locks[string(key)] = locks
+	}
+	return locks
+}
+
+// Register makes a storage available under name. It is meant to be called from an init
+// function, before the configuration is loaded, and panics if name is already taken.
+func Register(name string, backend Backend) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if backend.Open == nil {
+		panic("aferofs: Register of " + name + " without Open")
+	}
+	if slices.Contains(builtin, name) {
+		panic("aferofs: Register of the built-in filesystem " + name)
+	}
+	if _, exists := registry[name]; exists {
+		panic("aferofs: Register called twice for " + name)
+	}
+	registry[name] = &registered{backend: backend, locks: make(map[string]*afos.LockManager)}
+}
+
+// Lookup returns the storage registered under name.
+func Lookup(name string) (Backend, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	r, exists := registry[name]
+	if !exists {
+		return Backend{}, false
+	}
+	return r.backend, true
+}
+
+// Backends returns the names of the registered storages, sorted.
+func Backends() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Open creates a filesystem on the storage registered under name, opened with params.
+func Open(name string, params map[string]any, opts ...func(*Fs)) (fs.FS, error) {
+	registryMu.RLock()
+	r, exists := registry[name]
+	registryMu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("aferofs: unknown filesystem %q", name)
+	}
+	storage, err := r.backend.Open(params)
+	if err != nil {
+		return nil, fmt.Errorf("aferofs: opening %s: %w", name, err)
+	}
+	opts = append([]func(*Fs){WithType(name), WithLockManager(r.lockManager(params))}, opts...)
+	return New(storage, opts...), nil
+}
@@ -1,14 +1,13 @@
 package afos
 
 import (
-	"errors"
 	"io"
-	"io/ioutil"
+	"math/rand/v2"
 	"os"
-	"path"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
-	"sync"
 
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
@@ -17,6 +16,7 @@ import (
 
 	"github.com/oarkflow/ftp-server/errs"
 	"github.com/oarkflow/ftp-server/fs"
+	"github.com/oarkflow/ftp-server/utils"
 )
 
 // Afos ... A file system exposed to a user.
@@ -29,27 +29,25 @@ type Afos struct {
 	dataPath      string
 	permissions   int64
 	ctx           map[string]string
-	lock          sync.Mutex
+	locks         *LockManager
 	readOnly      bool
+	atomicUploads bool
 	sconn         *ssh.ServerConn
 }
 
 func defaultAfos(basePath string) *Afos {
-	dataPath := "data"
-	return &Afos{
-		dataPath: dataPath,
-		pathValidator: func(fs fs.FS, p string) (string, error) {
-			join := path.Join(basePath, dataPath, p)
-			clean := path.Clean(path.Join(basePath, dataPath))
-			if strings.HasPrefix(join, clean) {
-				return join, nil
-			}
-			return "", errors.New("invalid path outside the configured directory was provided")
-		},
+	f := &Afos{
+		basePath: basePath,
+		dataPath: "data",
+		locks:    NewLockManager(),
 		hasDiskSpace: func(fs fs.FS) bool {
 			return true // TODO
 		},
 	}
+	f.pathValidator = func(fs fs.FS, p string) (string, error) {
+		return ResolveBeneath(f.root(), p)
+	}
+	return f
 }
 
 func New(basePath string, opts ...func(*Afos)) fs.FS {
@@ -60,6 +58,14 @@ func New(basePath string, opts ...func(*Afos)) fs.FS {
 	return svr
 }
 
+func (f *Afos) SetReadOnly(readOnly bool) {
+	f.readOnly = readOnly
+}
+
+func (f *Afos) ReadOnly() bool {
+	return f.readOnly
+}
+
 func (f *Afos) SetPermissions(p []string) {
 	f.permissions = fs.Serialize(p)
 }
@@ -80,6 +86,16 @@ func (f *Afos) Context() map[string]string {
 	return f.ctx
 }
 
+// root returns the directory on disk that all client paths are resolved beneath.
+func (f *Afos) root() string {
+	return filepath.Join(utils.AbsPath(f.basePath), f.dataPath)
+}
+
+// LocalPath returns the location on disk of the client path p.
+func (f *Afos) LocalPath(p string) (string, error) {
+	return f.buildPath(p)
+}
+
 func (f *Afos) buildPath(p string) (string, error) {
 	if f.pathValidator == nil {
 		return "", nil
@@ -117,26 +133,30 @@ func (f *Afos) Fileread(request *sftp.Request) (io.ReaderAt, error) {
 		return nil, sftp.ErrSshFxNoSuchFile
 	}
 
-	f.lock.Lock()
-	defer f.lock.Unlock()
-
-	if _, err := os.Stat(p); os.IsNotExist(err) {
+	if _, err := statBeneath(f.root(), p); os.IsNotExist(err) {
 		return nil, sftp.ErrSshFxNoSuchFile
 	}
 
-	file, err := os.Open(p)
+	// Any number of sessions may read the file at once, but not while it is being written.
+	release, err := f.locks.TryRLock(p)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := openBeneath(f.root(), p, os.O_RDONLY, 0)
 	if err != nil {
+		release()
 		f.logger.Error("could not open file for reading", "source", p, "err", err)
 		return nil, sftp.ErrSshFxFailure
 	}
 
-	return file, nil
+	return f.newFile(file, release), nil
 }
 
 // Filewrite handles the write actions for a file on the system.
 func (f *Afos) Filewrite(request *sftp.Request) (io.WriterAt, error) {
 	if f.readOnly {
-		return nil, sftp.ErrSshFxOpUnsupported
+		return nil, sftp.ErrSshFxPermissionDenied
 	}
 
 	p, err := f.buildPath(request.Filepath)
@@ -150,10 +170,85 @@ func (f *Afos) Filewrite(request *sftp.Request) (io.WriterAt, error) {
 		return nil, errs.ErrSSHQuotaExceeded
 	}
 
-	f.lock.Lock()
-	defer f.lock.Unlock()
+	// Hold the file exclusively until the client closes the handle so that no other
+	// session can read a partial upload or write to the same file at the same time.
+	release, err := f.locks.TryLock(p)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := f.openWrite(request, p)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	fl := f.newFile(w, release)
+	if f.atomicUploads {
+		fl.target = p
+	}
+	return fl, nil
+}
+
+// create opens the file that an upload to p is written into. With atomic uploads enabled
+// this is a hidden temporary file next to p, which is only renamed over p once the upload
+// has completed successfully. existing is the file found at p, if any.
+func (f *Afos) create(p string, existing os.FileInfo) (*os.File, error) {
+	if !f.atomicUploads {
+		return openBeneath(f.root(), p, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	}
+	tmp, err := f.createTemp(p)
+	if err != nil {
+		return nil, err
+	}
+	// Temporary files are created private to the process, give the upload the mode the
+	// file it replaces has, or the one a regular upload would have ended up with.
+	var mode os.FileMode = 0644
+	if existing != nil {
+		mode = existing.Mode().Perm()
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		removeBeneath(f.root(), tmp.Name())
+		return nil, err
+	}
+	return tmp, nil
+}
+
+// partSuffix ... The extension of the temporary files atomic uploads are written to.
+const partSuffix = ".part"
+
+// isPart reports whether name is that of a temporary file createTemp made, such as
+// ".report.csv.1234.part".
+func isPart(name string) bool {
+	if !strings.HasPrefix(name, ".") || !strings.HasSuffix(name, partSuffix) {
+		return false
+	}
+	i := strings.LastIndexByte(strings.TrimSuffix(name, partSuffix), '.')
+	random := name[i+1 : len(name)-len(partSuffix)]
+	if i <= 1 || random == "" {
+		return false
+	}
+	_, err := strconv.ParseUint(random, 10, 32)
+	return err == nil
+}
+
+// createTemp creates a new, hidden, file next to p the way os.CreateTemp does, but through
+// openBeneath.
+func (f *Afos) createTemp(p string) (*os.File, error) {
+	for try := 0; ; try++ {
+		name := filepath.Join(filepath.Dir(p), "."+filepath.Base(p)+"."+strconv.FormatUint(uint64(rand.Uint32()), 10)+partSuffix)
+		file, err := openBeneath(f.root(), name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if os.IsExist(err) && try < 10000 {
+			continue
+		}
+		return file, err
+	}
+}
 
-	stat, statErr := os.Stat(p)
+// openWrite creates or truncates the file at p, checking the create and update permissions
+// depending on whether the file already exists.
+func (f *Afos) openWrite(request *sftp.Request, p string) (*os.File, error) {
+	stat, statErr := statBeneath(f.root(), p)
 	// If the file doesn't exist we need to create it, as well as the directory pathway
 	// leading up to where that file will be created.
 	if os.IsNotExist(statErr) {
@@ -164,7 +259,7 @@ func (f *Afos) Filewrite(request *sftp.Request) (io.WriterAt, error) {
 		}
 
 		// Create all of the directories leading up to the location where this file is being created.
-		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		if err := mkdirAllBeneath(f.root(), filepath.Dir(p), 0755); err != nil {
 			f.logger.Error("error making path for file",
 				"source", p,
 				"path", filepath.Dir(p),
@@ -173,7 +268,7 @@ func (f *Afos) Filewrite(request *sftp.Request) (io.WriterAt, error) {
 			return nil, sftp.ErrSshFxFailure
 		}
 
-		file, err := os.Create(p)
+		file, err := f.create(p, nil)
 		if err != nil {
 			f.logger.Error("error creating file", "source", p, "err", err)
 			return nil, sftp.ErrSshFxFailure
@@ -185,7 +280,7 @@ func (f *Afos) Filewrite(request *sftp.Request) (io.WriterAt, error) {
 	// If the stat error isn't about the file not existing, there is some other issue
 	// at play and we need to go ahead and bail out of the process.
 	if statErr != nil {
-		f.logger.Error("error performing file stat", "source", p, "err", err)
+		f.logger.Error("error performing file stat", "source", p, "err", statErr)
 		return nil, sftp.ErrSshFxFailure
 	}
 
@@ -204,7 +299,7 @@ func (f *Afos) Filewrite(request *sftp.Request) (io.WriterAt, error) {
 		return nil, sftp.ErrSshFxOpUnsupported
 	}
 
-	file, err := os.Create(p)
+	file, err := f.create(p, stat)
 	if err != nil {
 		f.logger.Error("error opening existing file",
 			"flags", request.Flags,
@@ -221,7 +316,7 @@ func (f *Afos) Filewrite(request *sftp.Request) (io.WriterAt, error) {
 // or writing to those files.
 func (f *Afos) Filecmd(request *sftp.Request) error {
 	if f.readOnly {
-		return sftp.ErrSshFxOpUnsupported
+		return sftp.ErrSshFxPermissionDenied
 	}
 
 	p, err := f.buildPath(request.Filepath)
@@ -240,6 +335,17 @@ func (f *Afos) Filecmd(request *sftp.Request) error {
 		}
 	}
 
+	switch request.Method {
+	case "Rename", "Remove":
+		// Renaming or removing a file that is in the middle of a transfer would pull it out
+		// from under the session that has it open.
+		release, err := f.lockPaths(p, target)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+
 	switch request.Method {
 	case "Setstat":
 		if !fs.Can(f.permissions, fs.Update) {
@@ -258,7 +364,7 @@ func (f *Afos) Filecmd(request *sftp.Request) error {
 			mode = 0755
 		}
 
-		if err := os.Chmod(p, mode); err != nil {
+		if err := chmodBeneath(f.root(), p, mode); err != nil {
 			f.logger.Error("failed to perform setstat", "err", err)
 			return sftp.ErrSshFxFailure
 		}
@@ -268,7 +374,7 @@ func (f *Afos) Filecmd(request *sftp.Request) error {
 			return sftp.ErrSshFxPermissionDenied
 		}
 
-		if err := os.Rename(p, target); err != nil {
+		if err := renameBeneath(f.root(), p, target); err != nil {
 			f.logger.Error("failed to rename file",
 				"source", p,
 				"target", target,
@@ -283,7 +389,7 @@ func (f *Afos) Filecmd(request *sftp.Request) error {
 			return sftp.ErrSshFxPermissionDenied
 		}
 
-		if err := os.RemoveAll(p); err != nil {
+		if err := removeAllBeneath(f.root(), p); err != nil {
 			f.logger.Error("failed to remove directory", "source", p, "err", err)
 			return sftp.ErrSshFxFailure
 		}
@@ -294,7 +400,7 @@ func (f *Afos) Filecmd(request *sftp.Request) error {
 			return sftp.ErrSshFxPermissionDenied
 		}
 
-		if err := os.MkdirAll(p, 0755); err != nil {
+		if err := mkdirAllBeneath(f.root(), p, 0755); err != nil {
 			f.logger.Error("failed to create directory", "source", p, "err", err)
 			return sftp.ErrSshFxFailure
 		}
@@ -305,7 +411,20 @@ func (f *Afos) Filecmd(request *sftp.Request) error {
 			return sftp.ErrSshFxPermissionDenied
 		}
 
-		if err := os.Symlink(p, target); err != nil {
+		// Store the link relative to its own directory so that it keeps resolving beneath
+		// the data directory instead of pointing at an absolute location on the host. A
+		// rename may later carry the link to where it points above the root, which is why
+		// links are checked whenever a path is resolved rather than only here.
+		link, err := filepath.Rel(filepath.Dir(target), p)
+		if err != nil {
+			f.logger.Error("failed to create symlink",
+				"source", p, "err", err,
+				"target", target,
+			)
+			return sftp.ErrSshFxFailure
+		}
+
+		if err := symlinkBeneath(f.root(), link, target); err != nil {
 			f.logger.Error("failed to create symlink",
 				"source", p, "err", err,
 				"target", target,
@@ -319,7 +438,7 @@ func (f *Afos) Filecmd(request *sftp.Request) error {
 			return sftp.ErrSshFxPermissionDenied
 		}
 
-		if err := os.Remove(p); err != nil {
+		if err := removeBeneath(f.root(), p); err != nil {
 			if !os.IsNotExist(err) {
 				f.logger.Error("failed to remove a file", "source", p, "err", err)
 			}
@@ -334,6 +453,29 @@ func (f *Afos) Filecmd(request *sftp.Request) error {
 	return sftp.ErrSshFxOk
 }
 
+// lockPaths takes an exclusive lock on every non-empty path given, releasing any lock that was
+// already taken if one of them is in use.
+func (f *Afos) lockPaths(paths ...string) (func(), error) {
+	var releases []func()
+	release := func() {
+		for _, r := range releases {
+			r()
+		}
+	}
+	for i, p := range paths {
+		if p == "" || slices.Contains(paths[:i], p) {
+			continue
+		}
+		r, err := f.locks.TryLock(p)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		releases = append(releases, r)
+	}
+	return release, nil
+}
+
 // Filelist is the handler for SFTP filesystem list calls. This will handle calls to list the contents of
 // a directory as well as perform file/folder stat calls.
 func (f *Afos) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
@@ -348,18 +490,24 @@ func (f *Afos) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
 			return nil, sftp.ErrSshFxPermissionDenied
 		}
 
-		files, err := ioutil.ReadDir(p)
-		if err != nil {
+		files, err := f.readDir(p)
+		if os.IsNotExist(err) {
+			return nil, sftp.ErrSshFxNoSuchFile
+		} else if err != nil {
 			f.logger.Error("error listing directory", "err", err)
 			return nil, sftp.ErrSshFxFailure
 		}
+		// Uploads in progress are not shown until they complete.
+		files = slices.DeleteFunc(files, func(file os.FileInfo) bool {
+			return !file.IsDir() && isPart(file.Name())
+		})
 		return fs.ListerAt(files), nil
 	case "Stat":
 		if !fs.Can(f.permissions, fs.Read) {
 			return nil, sftp.ErrSshFxPermissionDenied
 		}
 
-		s, err := os.Stat(p)
+		s, err := statBeneath(f.root(), p)
 		if os.IsNotExist(err) {
 			return nil, sftp.ErrSshFxNoSuchFile
 		} else if err != nil {
@@ -378,6 +526,24 @@ func (f *Afos) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
 	}
 }
 
+// readDir lists the directory p through openBeneath, sorted by name.
+func (f *Afos) readDir(p string) ([]os.FileInfo, error) {
+	dir, err := openBeneath(f.root(), p, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	files, err := dir.Readdir(-1)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(files, func(a, b os.FileInfo) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return files, nil
+}
+
 func (f *Afos) Type() string {
 	return "os"
 }
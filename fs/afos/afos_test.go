@@ -0,0 +1,111 @@
+package afos_test
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/oarkflow/ftp-server/fs"
+	"github.com/oarkflow/ftp-server/fs/afos"
+	"github.com/oarkflow/ftp-server/fs/fstest"
+	"github.com/oarkflow/ftp-server/log/oarklog"
+)
+
+// factory roots the filesystems a few directories beneath a temporary directory, deep enough
+// for the paths of the conformance suite that try to escape to land inside it, and checks
+// that nothing was created there outside of the root. A decoy sits where /existing.txt would be
+// one level above the root, for links that escape the root to give themselves away.
+func factory(opts ...func(*afos.Afos)) fstest.Factory {
+	return func(t *testing.T) func(permissions []string) fs.FS {
+		dir := t.TempDir()
+		base := filepath.Join(dir, "a", "b", "c")
+		if err := os.MkdirAll(filepath.Join(base, "data"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		decoy := filepath.Join(base, "existing.txt")
+		if err := os.WriteFile(decoy, []byte("outside of the root"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { expectConfined(t, dir, filepath.Join(base, "data"), decoy) })
+		return func(permissions []string) fs.FS {
+			fsys := afos.New(base, append([]func(*afos.Afos){afos.WithPermissions(permissions)}, opts...)...)
+			fsys.SetLogger(oarklog.Default())
+			return fsys
+		}
+	}
+}
+
+// expectConfined checks that dir holds nothing besides root, the directories leading to it
+// and the files in keep.
+func expectConfined(t *testing.T, dir, root string, keep ...string) {
+	t.Helper()
+	err := filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == root {
+			return filepath.SkipDir
+		}
+		if slices.Contains(keep, p) {
+			return nil
+		}
+		if rel, _ := filepath.Rel(p, root); !d.IsDir() || strings.HasPrefix(rel, "..") {
+			t.Errorf("%s was created outside of the root", p)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFS(t *testing.T) {
+	fstest.Run(t, factory())
+}
+
+func TestAtomicUploads(t *testing.T) {
+	fstest.Run(t, factory(afos.WithAtomicUploads(true)))
+}
+
+func TestAtomicUploadInProgress(t *testing.T) {
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data")
+	if err := os.MkdirAll(data, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(data, "existing.txt"), []byte(fstest.Content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fsys := afos.New(dir, afos.WithPermissions(fstest.AllPermissions), afos.WithAtomicUploads(true))
+	fsys.SetLogger(oarklog.Default())
+
+	w, err := fsys.Filewrite(fstest.Request("Put", "/existing.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.WriteAt([]byte("new"), 0); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(data)
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("expected the upload next to the file, found %v, %v", entries, err)
+	}
+	infos, err := fstest.List(fsys, "/")
+	if err != nil || len(infos) != 1 || infos[0].Name() != "existing.txt" {
+		t.Fatalf("listed %v, %v, want existing.txt alone", infos, err)
+	}
+	if err := w.(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(filepath.Join(data, "existing.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("upload changed the mode to %v, want %v", info.Mode().Perm(), os.FileMode(0600))
+	}
+}
@@ -0,0 +1,278 @@
+package afos
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// The functions below change p, a path ResolveBeneath returned for root, relative to the
+// directory holding it. That directory is opened through openat2(RESOLVE_BENEATH), so a
+// parent swapped for a symlink after the path was resolved cannot lead the change outside
+// of the root. The final component is never followed. Without openat2 the change is made on
+// p itself, which the manual walk already confined.
+
+// openat2 opens rel beneath dirfd. Kernels without openat2, or sandboxes filtering it, report
+// errOpenat2Unavailable.
+func openat2(dirfd int, rel string, flag int) (int, error) {
+	fd, err := unix.Openat2(dirfd, rel, &unix.OpenHow{
+		Flags:   uint64(flag | unix.O_CLOEXEC),
+		Resolve: unix.RESOLVE_BENEATH | unix.RESOLVE_NO_MAGICLINKS,
+	})
+	switch {
+	case err == nil:
+		return fd, nil
+	case errors.Is(err, unix.EXDEV):
+		return -1, ErrPathEscape
+	case errors.Is(err, unix.ENOSYS),
+		errors.Is(err, unix.EPERM),
+		errors.Is(err, unix.EINVAL):
+		return -1, errOpenat2Unavailable
+	}
+	return -1, err
+}
+
+// relBeneath returns p relative to root, refusing paths that are not beneath it.
+func relBeneath(root, p string) (string, error) {
+	rel, err := filepath.Rel(root, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", ErrPathEscape
+	}
+	return rel, nil
+}
+
+// openParent opens the directory holding p and returns it along with the name of p in it.
+func openParent(root, p string) (int, string, error) {
+	rel, err := relBeneath(root, p)
+	if err != nil {
+		return -1, "", err
+	}
+	// The root itself is never renamed or removed by a client.
+	if rel == "." {
+		return -1, "", os.ErrPermission
+	}
+	rootFd, err := unix.Open(root, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, "", err
+	}
+	defer unix.Close(rootFd)
+
+	fd, err := openat2(rootFd, filepath.Dir(rel), unix.O_PATH|unix.O_DIRECTORY)
+	if err != nil {
+		return -1, "", err
+	}
+	return fd, filepath.Base(rel), nil
+}
+
+func pathError(op, p string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &os.PathError{Op: op, Path: p, Err: err}
+}
+
+// statBeneath returns the FileInfo describing p.
+func statBeneath(root, p string) (os.FileInfo, error) {
+	rel, err := relBeneath(root, p)
+	if err != nil {
+		return nil, err
+	}
+	rootFd, err := unix.Open(root, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, pathError("stat", p, err)
+	}
+	defer unix.Close(rootFd)
+
+	fd, err := openat2(rootFd, rel, unix.O_PATH|unix.O_NOFOLLOW)
+	if errors.Is(err, errOpenat2Unavailable) {
+		return os.Stat(p)
+	}
+	if err != nil {
+		return nil, pathError("stat", p, err)
+	}
+	file := os.NewFile(uintptr(fd), p)
+	defer file.Close()
+	return file.Stat()
+}
+
+// chmodBeneath changes the mode of p. Linux has no fchmodat that leaves a symlink alone, so
+// the file is opened with O_PATH and changed through its descriptor in /proc instead.
+func chmodBeneath(root, p string, mode os.FileMode) error {
+	rel, err := relBeneath(root, p)
+	if err != nil {
+		return err
+	}
+	rootFd, err := unix.Open(root, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return pathError("chmod", p, err)
+	}
+	defer unix.Close(rootFd)
+
+	fd, err := openat2(rootFd, rel, unix.O_PATH|unix.O_NOFOLLOW)
+	if errors.Is(err, errOpenat2Unavailable) {
+		return os.Chmod(p, mode)
+	}
+	if err != nil {
+		return pathError("chmod", p, err)
+	}
+	defer unix.Close(fd)
+
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		return pathError("chmod", p, err)
+	}
+	if st.Mode&unix.S_IFMT == unix.S_IFLNK {
+		return pathError("chmod", p, unix.ELOOP)
+	}
+	return pathError("chmod", p, unix.Fchmodat(unix.AT_FDCWD, "/proc/self/fd/"+strconv.Itoa(fd), uint32(mode.Perm()), 0))
+}
+
+// mkdirAllBeneath creates the directory p along with any parents it is missing, one
+// component at a time, never following a symlink in place of a directory.
+func mkdirAllBeneath(root, p string, perm os.FileMode) error {
+	rel, err := relBeneath(root, p)
+	if err != nil {
+		return err
+	}
+	// The root is configured by the operator, not the client, and may not exist yet.
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return err
+	}
+	if rel == "." {
+		return nil
+	}
+	dirFd, err := unix.Open(root, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return pathError("mkdir", p, err)
+	}
+	for _, name := range strings.Split(rel, "/") {
+		if err := unix.Mkdirat(dirFd, name, uint32(perm.Perm())); err != nil && !errors.Is(err, unix.EEXIST) {
+			unix.Close(dirFd)
+			return pathError("mkdir", p, err)
+		}
+		fd, err := openat2(dirFd, name, unix.O_PATH|unix.O_DIRECTORY|unix.O_NOFOLLOW)
+		unix.Close(dirFd)
+		if errors.Is(err, errOpenat2Unavailable) {
+			return os.MkdirAll(p, perm)
+		}
+		if err != nil {
+			return pathError("mkdir", p, err)
+		}
+		dirFd = fd
+	}
+	unix.Close(dirFd)
+	return nil
+}
+
+// removeBeneath removes the file or empty directory p.
+func removeBeneath(root, p string) error {
+	dirFd, name, err := openParent(root, p)
+	if errors.Is(err, errOpenat2Unavailable) {
+		return os.Remove(p)
+	}
+	if err != nil {
+		return pathError("remove", p, err)
+	}
+	defer unix.Close(dirFd)
+
+	err = unix.Unlinkat(dirFd, name, 0)
+	if errors.Is(err, unix.EISDIR) {
+		err = unix.Unlinkat(dirFd, name, unix.AT_REMOVEDIR)
+	}
+	return pathError("remove", p, err)
+}
+
+// removeAllBeneath removes p and everything it contains. Like os.RemoveAll it succeeds if p
+// does not exist.
+func removeAllBeneath(root, p string) error {
+	dirFd, name, err := openParent(root, p)
+	if errors.Is(err, errOpenat2Unavailable) {
+		return os.RemoveAll(p)
+	}
+	if errors.Is(err, unix.ENOENT) {
+		return nil
+	}
+	if err != nil {
+		return pathError("removeall", p, err)
+	}
+	defer unix.Close(dirFd)
+	return pathError("removeall", p, removeAllAt(dirFd, name))
+}
+
+func removeAllAt(dirFd int, name string) error {
+	err := unix.Unlinkat(dirFd, name, 0)
+	if err == nil || errors.Is(err, unix.ENOENT) {
+		return nil
+	}
+	if !errors.Is(err, unix.EISDIR) {
+		return err
+	}
+
+	fd, err := unix.Openat(dirFd, name, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if errors.Is(err, unix.ENOENT) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	dir := os.NewFile(uintptr(fd), name)
+	names, err := dir.Readdirnames(-1)
+	for _, child := range names {
+		if err != nil {
+			break
+		}
+		err = removeAllAt(fd, child)
+	}
+	dir.Close()
+	if err != nil {
+		return err
+	}
+
+	err = unix.Unlinkat(dirFd, name, unix.AT_REMOVEDIR)
+	if errors.Is(err, unix.ENOENT) {
+		return nil
+	}
+	return err
+}
+
+// renameBeneath moves from to to, replacing to if it exists.
+func renameBeneath(root, from, to string) error {
+	fromFd, fromName, err := openParent(root, from)
+	if errors.Is(err, errOpenat2Unavailable) {
+		return os.Rename(from, to)
+	}
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: err}
+	}
+	defer unix.Close(fromFd)
+
+	toFd, toName, err := openParent(root, to)
+	if err == nil {
+		defer unix.Close(toFd)
+		err = unix.Renameat(fromFd, fromName, toFd, toName)
+	}
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: err}
+	}
+	return nil
+}
+
+// symlinkBeneath creates link as a symlink holding target.
+func symlinkBeneath(root, target, link string) error {
+	dirFd, name, err := openParent(root, link)
+	if errors.Is(err, errOpenat2Unavailable) {
+		return os.Symlink(target, link)
+	}
+	if err == nil {
+		defer unix.Close(dirFd)
+		err = unix.Symlinkat(target, dirFd, name)
+	}
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+//go:build !linux
+
+package afos
+
+import "os"
+
+// Without openat2 the changes below are made on p itself, which the manual walk in
+// ResolveBeneath already confined to the root.
+
+// statBeneath returns the FileInfo describing p.
+func statBeneath(root, p string) (os.FileInfo, error) {
+	return os.Stat(p)
+}
+
+// chmodBeneath changes the mode of p.
+func chmodBeneath(root, p string, mode os.FileMode) error {
+	return os.Chmod(p, mode)
+}
+
+// mkdirAllBeneath creates the directory p along with any parents it is missing.
+func mkdirAllBeneath(root, p string, perm os.FileMode) error {
+	return os.MkdirAll(p, perm)
+}
+
+// removeBeneath removes the file or empty directory p.
+func removeBeneath(root, p string) error {
+	return os.Remove(p)
+}
+
+// removeAllBeneath removes p and everything it contains.
+func removeAllBeneath(root, p string) error {
+	return os.RemoveAll(p)
+}
+
+// renameBeneath moves from to to, replacing to if it exists.
+func renameBeneath(root, from, to string) error {
+	return os.Rename(from, to)
+}
+
+// symlinkBeneath creates link as a symlink holding target.
+func symlinkBeneath(root, target, link string) error {
+	return os.Symlink(target, link)
+}
@@ -0,0 +1,47 @@
+package afos
+
+import (
+	"os"
+)
+
+// file ... An open file handed over to the SFTP server. The lock taken on the path is held
+// for as long as the client keeps the handle open.
+type file struct {
+	*os.File
+	release func()
+	// root is the directory the file was opened beneath.
+	root string
+	// target is set for atomic uploads, where File is a temporary file that replaces
+	// target once the upload completes.
+	target      string
+	transferErr error
+}
+
+func (f *Afos) newFile(fl *os.File, release func()) *file {
+	return &file{File: fl, release: release, root: f.root()}
+}
+
+// TransferError is called by the SFTP server when the connection is lost while the handle
+// is still open, so that an interrupted atomic upload is discarded rather than published.
+func (f *file) TransferError(err error) {
+	f.transferErr = err
+}
+
+// Close closes the file and releases the lock held on its path. For atomic uploads the
+// temporary file is moved into place, or removed if the transfer did not complete.
+func (f *file) Close() error {
+	defer f.release()
+
+	err := f.File.Close()
+	if f.target == "" {
+		return err
+	}
+	if err == nil && f.transferErr == nil {
+		if err = renameBeneath(f.root, f.Name(), f.target); err == nil {
+			return nil
+		}
+	}
+	// The upload is incomplete, make sure nothing is left behind for anyone to pick up.
+	removeBeneath(f.root, f.Name())
+	return err
+}
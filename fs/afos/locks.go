@@ -0,0 +1,82 @@
+package afos
+
+import (
+	"sync"
+
+	"github.com/oarkflow/ftp-server/errs"
+)
+
+// LockManager ... Tracks the paths that are currently open for reading or writing. A single
+// manager is meant to be shared by every session of a server so that two sessions working
+// on the same data directory cannot clobber each other's transfers.
+type LockManager struct {
+	mu    sync.Mutex
+	locks map[string]*pathLock
+}
+
+type pathLock struct {
+	readers int
+	writer  bool
+}
+
+// NewLockManager ... Creates an empty lock manager.
+func NewLockManager() *LockManager {
+	return &LockManager{locks: make(map[string]*pathLock)}
+}
+
+// TryRLock acquires a shared lock on the path, which any number of readers may hold at the
+// same time. It fails with errs.ErrSSHLockConflict if the path is currently being written.
+// The returned function releases the lock and is safe to call more than once.
+func (m *LockManager) TryRLock(p string) (func(), error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l := m.locks[p]
+	if l == nil {
+		l = &pathLock{}
+		m.locks[p] = l
+	}
+	if l.writer {
+		return nil, errs.ErrSSHLockConflict
+	}
+	l.readers++
+	return m.release(p, func(l *pathLock) { l.readers-- }), nil
+}
+
+// TryLock acquires an exclusive lock on the path. It fails with errs.ErrSSHLockConflict if
+// the path is currently being read or written by anyone else. The returned function releases
+// the lock and is safe to call more than once.
+func (m *LockManager) TryLock(p string) (func(), error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l := m.locks[p]
+	if l == nil {
+		l = &pathLock{}
+		m.locks[p] = l
+	}
+	if l.writer || l.readers > 0 {
+		return nil, errs.ErrSSHLockConflict
+	}
+	l.writer = true
+	return m.release(p, func(l *pathLock) { l.writer = false }), nil
+}
+
+func (m *LockManager) release(p string, fn func(l *pathLock)) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+
+			l := m.locks[p]
+			if l == nil {
+				return
+			}
+			fn(l)
+			if !l.writer && l.readers == 0 {
+				delete(m.locks, p)
+			}
+		})
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package afos
+
+import "os"
+
+// openNoFollow opens p. Platforms without O_NOFOLLOW rely on the resolver alone.
+func openNoFollow(p string, flag int, perm os.FileMode) (*os.File, error) {
+	return os.OpenFile(p, flag, perm)
+}
@@ -0,0 +1,13 @@
+//go:build unix
+
+package afos
+
+import (
+	"os"
+	"syscall"
+)
+
+// openNoFollow opens p, refusing to follow it if it is a symlink.
+func openNoFollow(p string, flag int, perm os.FileMode) (*os.File, error) {
+	return os.OpenFile(p, flag|syscall.O_NOFOLLOW, perm)
+}
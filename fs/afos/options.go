@@ -33,3 +33,19 @@ func WithPathValidator(val func(fs fs.FS, p string) (string, error)) func(server
 		o.pathValidator = val
 	}
 }
+
+// WithLockManager shares a lock manager between filesystems, typically every session of a server,
+// so that concurrent transfers of the same file are detected across sessions.
+func WithLockManager(val *LockManager) func(server *Afos) {
+	return func(o *Afos) {
+		o.locks = val
+	}
+}
+
+// WithAtomicUploads writes uploads to a hidden temporary file in the same directory, which is
+// renamed to the final name only once the client closes the handle after a complete transfer.
+func WithAtomicUploads(val bool) func(server *Afos) {
+	return func(o *Afos) {
+		o.atomicUploads = val
+	}
+}
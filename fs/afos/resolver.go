@@ -0,0 +1,92 @@
+package afos
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// maxSymlinks ... The number of symlinks that will be followed while resolving a single path
+// before giving up, mirroring the limit applied by the kernel.
+const maxSymlinks = 40
+
+var (
+	// ErrPathEscape ... Returned when a path would resolve to a location outside the data directory.
+	ErrPathEscape = errors.New("invalid path outside the configured directory was provided")
+	// ErrTooManySymlinks ... Returned when resolving a path requires following too many symlinks.
+	ErrTooManySymlinks = errors.New("too many levels of symbolic links")
+)
+
+// ResolveBeneath resolves the client supplied path p to a location on disk that is guaranteed
+// to sit beneath root. Every component of the path is resolved individually so that a symlink
+// inside the root that points outside of it is rejected, rather than being followed blindly
+// the way a simple prefix check on the joined path would. Components that do not exist yet
+// are appended as-is, which allows the result to be used for files and directories that are
+// about to be created.
+func ResolveBeneath(root, p string) (string, error) {
+	root = filepath.Clean(root)
+	// Cleaning the path as if it were absolute removes any leading ".." elements, so the
+	// lexical form of the path can never leave the root on its own.
+	rel := strings.TrimPrefix(path.Clean("/"+p), "/")
+	if rel == "" {
+		return root, nil
+	}
+	return resolveBeneath(root, rel)
+}
+
+// walkBeneath is the portable resolver. It walks the path one component at a time using
+// Lstat so that symlinks are never followed implicitly, and splices the target of each
+// symlink it meets into the remaining components. Absolute symlinks, and relative ones
+// that climb above the root, are rejected.
+func walkBeneath(root, rel string) (string, error) {
+	var resolved []string
+	pending := strings.Split(rel, "/")
+	links := 0
+	for len(pending) > 0 {
+		name := pending[0]
+		pending = pending[1:]
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			if len(resolved) == 0 {
+				return "", ErrPathEscape
+			}
+			resolved = resolved[:len(resolved)-1]
+			continue
+		}
+
+		current := filepath.Join(root, filepath.Join(resolved...), name)
+		info, err := os.Lstat(current)
+		if err != nil {
+			// Anything below a missing component does not exist either, so there is nothing
+			// left that could be followed outside of the root.
+			if os.IsNotExist(err) || errors.Is(err, os.ErrNotExist) {
+				resolved = append(resolved, name)
+				continue
+			}
+			return "", err
+		}
+
+		if info.Mode()&os.ModeSymlink == 0 {
+			resolved = append(resolved, name)
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", ErrTooManySymlinks
+		}
+		target, err := os.Readlink(current)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			return "", ErrPathEscape
+		}
+		pending = append(strings.Split(filepath.ToSlash(target), "/"), pending...)
+	}
+	return filepath.Join(root, filepath.Join(resolved...)), nil
+}
@@ -0,0 +1,124 @@
+package afos
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// errOpenat2Unavailable ... Signals that openat2 could not be used and the path needs to be
+// resolved by walking it manually instead.
+var errOpenat2Unavailable = errors.New("openat2 is not available")
+
+// resolveBeneath resolves rel using openat2(RESOLVE_BENEATH), which has the kernel refuse any
+// resolution that would leave the root, whether through ".." or a symlink. Kernels older than
+// 5.6, or sandboxes that filter the syscall, fall back to the manual walk.
+func resolveBeneath(root, rel string) (string, error) {
+	resolved, err := openat2Beneath(root, rel)
+	switch {
+	case err == nil:
+		return resolved, nil
+	case errors.Is(err, unix.EXDEV):
+		return "", ErrPathEscape
+	case errors.Is(err, unix.ELOOP):
+		return "", ErrTooManySymlinks
+	case errors.Is(err, errOpenat2Unavailable),
+		errors.Is(err, unix.ENOSYS),
+		errors.Is(err, unix.EPERM),
+		errors.Is(err, unix.EINVAL):
+		return walkBeneath(root, rel)
+	}
+	return "", err
+}
+
+// openBeneath opens p, a path ResolveBeneath returned for root, through openat2 so that a
+// symlink swapped into the path after it was resolved still cannot lead outside of the root.
+// The final component is never followed. Without openat2 it only gets the O_NOFOLLOW guard.
+func openBeneath(root, p string, flag int, perm os.FileMode) (*os.File, error) {
+	rel, err := relBeneath(root, p)
+	if err != nil {
+		return nil, err
+	}
+	rootFd, err := unix.Open(root, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: root, Err: err}
+	}
+	defer unix.Close(rootFd)
+
+	how := &unix.OpenHow{
+		Flags:   uint64(flag | unix.O_NOFOLLOW | unix.O_CLOEXEC),
+		Resolve: unix.RESOLVE_BENEATH | unix.RESOLVE_NO_MAGICLINKS,
+	}
+	// The kernel rejects a mode that comes without O_CREAT.
+	if flag&os.O_CREATE != 0 {
+		how.Mode = uint64(perm.Perm())
+	}
+	fd, err := unix.Openat2(rootFd, rel, how)
+	switch {
+	case err == nil:
+		return os.NewFile(uintptr(fd), p), nil
+	case errors.Is(err, unix.EXDEV):
+		return nil, ErrPathEscape
+	case errors.Is(err, unix.ENOSYS),
+		errors.Is(err, unix.EPERM),
+		errors.Is(err, unix.EINVAL):
+		return openNoFollow(p, flag, perm)
+	}
+	return nil, &os.PathError{Op: "open", Path: p, Err: err}
+}
+
+func openat2Beneath(root, rel string) (string, error) {
+	rootFd, err := unix.Open(root, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		// Nothing exists beneath a root that has not been created yet, so there is
+		// nothing that could be followed outside of it either.
+		if errors.Is(err, unix.ENOENT) {
+			return filepath.Join(root, rel), nil
+		}
+		return "", err
+	}
+	defer unix.Close(rootFd)
+
+	// Find the deepest component of the path that already exists, everything after it
+	// is going to be created by the caller.
+	components := strings.Split(rel, "/")
+	for i := len(components); i > 0; i-- {
+		fd, err := unix.Openat2(rootFd, strings.Join(components[:i], "/"), &unix.OpenHow{
+			Flags:   unix.O_PATH | unix.O_CLOEXEC,
+			Resolve: unix.RESOLVE_BENEATH | unix.RESOLVE_NO_MAGICLINKS,
+		})
+		if errors.Is(err, unix.ENOENT) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		resolved, err := openat2Finish(fd, components, i)
+		unix.Close(fd)
+		return resolved, err
+	}
+	return walkBeneath(root, rel)
+}
+
+func openat2Finish(fd int, components []string, i int) (string, error) {
+	resolved, err := os.Readlink("/proc/self/fd/" + strconv.Itoa(fd))
+	if err != nil {
+		return "", errOpenat2Unavailable
+	}
+	if i == len(components) {
+		return resolved, nil
+	}
+
+	// The next component is missing when followed. If it exists as a dangling symlink
+	// leave the decision of where it points to the manual walk.
+	var st unix.Stat_t
+	if err := unix.Fstatat(fd, components[i], &st, unix.AT_SYMLINK_NOFOLLOW); err == nil && st.Mode&unix.S_IFMT == unix.S_IFLNK {
+		return "", errOpenat2Unavailable
+	}
+	return filepath.Join(append([]string{resolved}, components[i:]...)...), nil
+}
@@ -0,0 +1,102 @@
+package afos
+
+import (
+	"cmp"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func skipWithoutOpenat2(t *testing.T) {
+	t.Helper()
+	if _, err := unix.Openat2(unix.AT_FDCWD, ".", &unix.OpenHow{Flags: unix.O_PATH | unix.O_CLOEXEC}); err != nil {
+		t.Skipf("openat2 is not available: %v", err)
+	}
+}
+
+func TestOpenBeneathSwappedDirectory(t *testing.T) {
+	skipWithoutOpenat2(t)
+	root, sibling := newRoot(t)
+	if err := os.WriteFile(filepath.Join(sibling, "file.txt"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := ResolveBeneath(root, "dir/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Swap a parent directory for a symlink leading outside of the root once the path has
+	// been resolved, the final component is not a symlink.
+	if err := os.RemoveAll(filepath.Join(root, "dir")); err != nil {
+		t.Fatal(err)
+	}
+	symlink(t, sibling, filepath.Join(root, "dir"))
+
+	file, err := openBeneath(root, p, os.O_RDONLY, 0)
+	if err == nil {
+		file.Close()
+	}
+	if !errors.Is(err, ErrPathEscape) {
+		t.Fatalf("got %v, want %v", err, ErrPathEscape)
+	}
+}
+
+// TestChangeBeneathSwappedDirectory makes every change a client can request on a path whose
+// parent directory was swapped for a symlink leading outside of the root once resolved.
+func TestChangeBeneathSwappedDirectory(t *testing.T) {
+	skipWithoutOpenat2(t)
+	tests := []struct {
+		name   string
+		change func(root, p string) error
+		// want is ErrPathEscape unless set.
+		want error
+	}{
+		{name: "stat", change: func(root, p string) error {
+			_, err := statBeneath(root, filepath.Join(p, "secret.txt"))
+			return err
+		}},
+		{name: "chmod", change: func(root, p string) error { return chmodBeneath(root, filepath.Join(p, "secret.txt"), 0600) }},
+		{name: "mkdir", change: func(root, p string) error { return mkdirAllBeneath(root, filepath.Join(p, "new"), 0755) },
+			// Directories are created one component at a time, refusing any symlink.
+			want: unix.ENOTDIR},
+		{name: "remove", change: func(root, p string) error { return removeBeneath(root, filepath.Join(p, "secret.txt")) }},
+		{name: "removeall", change: func(root, p string) error { return removeAllBeneath(root, filepath.Join(p, "secret.txt")) }},
+		{name: "rename from", change: func(root, p string) error {
+			return renameBeneath(root, filepath.Join(p, "secret.txt"), filepath.Join(root, "stolen.txt"))
+		}},
+		{name: "rename to", change: func(root, p string) error {
+			return renameBeneath(root, filepath.Join(root, "dir", "file.txt"), filepath.Join(p, "secret.txt"))
+		}},
+		{name: "symlink", change: func(root, p string) error { return symlinkBeneath(root, "file.txt", filepath.Join(p, "link")) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, sibling := newRoot(t)
+			if err := os.MkdirAll(filepath.Join(root, "swapped"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			p, err := ResolveBeneath(root, "swapped")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Remove(p); err != nil {
+				t.Fatal(err)
+			}
+			symlink(t, sibling, p)
+
+			want := cmp.Or(tt.want, ErrPathEscape)
+			if err := tt.change(root, p); !errors.Is(err, want) {
+				t.Fatalf("got %v, want %v", err, want)
+			}
+			entries, err := os.ReadDir(sibling)
+			if err != nil || len(entries) != 1 || entries[0].Name() != "secret.txt" {
+				t.Fatalf("the directory outside of the root holds %v, %v", entries, err)
+			}
+			if info, err := entries[0].Info(); err != nil || info.Mode().Perm() != 0644 {
+				t.Fatalf("the file outside of the root changed: %v, %v", info, err)
+			}
+		})
+	}
+}
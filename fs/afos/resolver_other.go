@@ -0,0 +1,16 @@
+//go:build !linux
+
+package afos
+
+import "os"
+
+// resolveBeneath resolves rel by walking it manually, openat2 is only available on Linux.
+func resolveBeneath(root, rel string) (string, error) {
+	return walkBeneath(root, rel)
+}
+
+// openBeneath opens p, a path ResolveBeneath returned for root, without following a symlink
+// that was swapped in as its final component after it was resolved.
+func openBeneath(root, p string, flag int, perm os.FileMode) (*os.File, error) {
+	return openNoFollow(p, flag, perm)
+}
@@ -0,0 +1,117 @@
+package afos
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newRoot creates the layout the resolver tests share: a data directory as the root, with a
+// data2 directory next to it whose name shares the root as a prefix.
+func newRoot(t *testing.T) (root, sibling string) {
+	t.Helper()
+	dir := t.TempDir()
+	root = filepath.Join(dir, "data")
+	sibling = filepath.Join(dir, "data2")
+	for _, d := range []string{filepath.Join(root, "dir"), sibling} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []string{filepath.Join(root, "dir", "file.txt"), filepath.Join(sibling, "secret.txt")} {
+		if err := os.WriteFile(f, []byte("content"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root, sibling
+}
+
+func symlink(t *testing.T, target, link string) {
+	t.Helper()
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var resolvers = []struct {
+	name    string
+	resolve func(root, p string) (string, error)
+}{
+	{"ResolveBeneath", ResolveBeneath},
+	// The portable resolver, which Linux falls back to without openat2.
+	{"walkBeneath", func(root, p string) (string, error) {
+		return walkBeneath(root, strings.TrimPrefix(path.Clean("/"+p), "/"))
+	}},
+}
+
+func TestResolveBeneath(t *testing.T) {
+	root, sibling := newRoot(t)
+	symlink(t, "../data2", filepath.Join(root, "relative"))
+	symlink(t, sibling, filepath.Join(root, "absolute"))
+	symlink(t, "dir/file.txt", filepath.Join(root, "inside"))
+	symlink(t, "../../data/dir", filepath.Join(root, "dir", "roundtrip"))
+	symlink(t, "loop", filepath.Join(root, "loop"))
+
+	tests := []struct {
+		path string
+		want string
+		err  error
+	}{
+		{path: "dir/file.txt", want: "dir/file.txt"},
+		{path: "dir/missing/new.txt", want: "dir/missing/new.txt"},
+		{path: "inside", want: "dir/file.txt"},
+		// Lexically, ".." never climbs above the root.
+		{path: "../data2/secret.txt", want: "data2/secret.txt"},
+		{path: "relative/secret.txt", err: ErrPathEscape},
+		{path: "relative", err: ErrPathEscape},
+		{path: "absolute/secret.txt", err: ErrPathEscape},
+		// Leaving the root through a symlink is rejected even when the path leads back into it.
+		{path: "dir/roundtrip/file.txt", err: ErrPathEscape},
+		{path: "loop", err: ErrTooManySymlinks},
+	}
+	for _, r := range resolvers {
+		for _, tt := range tests {
+			t.Run(r.name+"/"+tt.path, func(t *testing.T) {
+				got, err := r.resolve(root, tt.path)
+				if tt.err != nil {
+					if !errors.Is(err, tt.err) {
+						t.Fatalf("got %q, %v, want %v", got, err, tt.err)
+					}
+					return
+				}
+				want := filepath.Join(root, tt.want)
+				if err != nil || got != want {
+					t.Fatalf("got %q, %v, want %q", got, err, want)
+				}
+			})
+		}
+	}
+}
+
+func TestOpenBeneathSwappedSymlink(t *testing.T) {
+	root, sibling := newRoot(t)
+	p, err := ResolveBeneath(root, "dir/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Swap the file for a symlink leading outside of the root once it has been resolved.
+	if err := os.Remove(p); err != nil {
+		t.Fatal(err)
+	}
+	symlink(t, filepath.Join(sibling, "secret.txt"), p)
+
+	if file, err := openBeneath(root, p, os.O_RDONLY, 0); err == nil {
+		file.Close()
+		t.Fatal("opened a symlink leading outside of the root")
+	}
+	if file, err := openBeneath(root, p, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err == nil {
+		file.Close()
+		t.Fatal("truncated a file outside of the root")
+	}
+	if data, err := os.ReadFile(filepath.Join(sibling, "secret.txt")); err != nil || string(data) != "content" {
+		t.Fatalf("file outside of the root holds %q, %v", data, err)
+	}
+}
@@ -18,6 +18,10 @@ type FS interface {
 	Logger() log.Logger
 	SetPermissions(p []string)
 	Permissions() []string
+	// SetReadOnly refuses every write and change to the filesystem with permission denied
+	// errors, whatever the permissions.
+	SetReadOnly(readOnly bool)
+	ReadOnly() bool
 	SetContext(ctx map[string]string)
 	Context() map[string]string
 	SetConn(sconn *ssh.ServerConn)
@@ -0,0 +1,282 @@
+package fstest
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+
+	"github.com/oarkflow/ftp-server/fs"
+)
+
+// Run runs every scenario of the package against the file systems newFS creates: TestFS and
+// TestPermissions.
+func Run(t *testing.T, newFS Factory) {
+	t.Run("conformance", func(t *testing.T) { TestFS(t, newFS) })
+	t.Run("permissions", func(t *testing.T) { TestPermissions(t, newFS) })
+}
+
+// TestFS checks that a file system granted every permission reads, writes, lists and changes
+// files the way SFTP clients expect, and keeps every path beneath its root. Each scenario
+// starts from the files Seed creates.
+func TestFS(t *testing.T, newFS Factory) {
+	for _, c := range conformance {
+		t.Run(c.name, func(t *testing.T) {
+			fsys := newFS(t)(AllPermissions)
+			Seed(t, fsys)
+			c.run(t, fsys)
+		})
+	}
+}
+
+var conformance = []struct {
+	name string
+	run  func(t *testing.T, fsys fs.FS)
+}{
+	{"read file", func(t *testing.T, fsys fs.FS) {
+		expectContent(t, fsys, "/existing.txt", Content)
+	}},
+	{"read missing file", func(t *testing.T, fsys fs.FS) {
+		_, err := ReadFile(fsys, "/missing.txt")
+		expectError(t, err, sftp.ErrSshFxNoSuchFile)
+	}},
+	{"create file in new directories", func(t *testing.T, fsys fs.FS) {
+		expectOk(t, WriteFile(fsys, "/a/b/new.txt", []byte("new")))
+		expectContent(t, fsys, "/a/b/new.txt", "new")
+		expectDir(t, fsys, "/a/b")
+	}},
+	{"overwrite file with shorter content", func(t *testing.T, fsys fs.FS) {
+		expectOk(t, WriteFile(fsys, "/existing.txt", []byte("short")))
+		expectContent(t, fsys, "/existing.txt", "short")
+	}},
+	{"write out of order", func(t *testing.T, fsys fs.FS) {
+		w, err := fsys.Filewrite(Request("Put", "/chunks.txt"))
+		expectOk(t, err)
+		// Clients pipeline their writes, which may reach the server in any order.
+		for _, chunk := range []struct {
+			data   string
+			offset int64
+		}{{"world", 6}, {"hello ", 0}, {"!", 11}} {
+			if _, err := w.WriteAt([]byte(chunk.data), chunk.offset); err != nil {
+				t.Fatalf("writing at %d: %v", chunk.offset, err)
+			}
+		}
+		if c, ok := w.(io.Closer); ok {
+			expectOk(t, c.Close())
+		}
+		expectContent(t, fsys, "/chunks.txt", "hello world!")
+	}},
+	{"stat file", func(t *testing.T, fsys fs.FS) {
+		info, err := Stat(fsys, "/existing.txt")
+		expectOk(t, err)
+		if info.Name() != "existing.txt" || info.IsDir() || info.Size() != int64(len(Content)) {
+			t.Fatalf("got %s, directory %t, %d bytes, want existing.txt, a file of %d bytes",
+				info.Name(), info.IsDir(), info.Size(), len(Content))
+		}
+	}},
+	{"stat directory", func(t *testing.T, fsys fs.FS) {
+		expectDir(t, fsys, "/dir")
+		expectDir(t, fsys, "/")
+	}},
+	{"stat missing file", func(t *testing.T, fsys fs.FS) {
+		_, err := Stat(fsys, "/missing.txt")
+		expectError(t, err, sftp.ErrSshFxNoSuchFile)
+	}},
+	{"list root", func(t *testing.T, fsys fs.FS) {
+		expectEntries(t, fsys, "/", "dir/", "existing.txt")
+	}},
+	{"list directory", func(t *testing.T, fsys fs.FS) {
+		expectEntries(t, fsys, "/dir", "inner.txt")
+	}},
+	{"list missing directory", func(t *testing.T, fsys fs.FS) {
+		_, err := List(fsys, "/missing")
+		expectError(t, err, sftp.ErrSshFxNoSuchFile)
+	}},
+	{"setstat", func(t *testing.T, fsys fs.FS) {
+		expectOk(t, Setstat(fsys, "/existing.txt", 0600))
+		expectContent(t, fsys, "/existing.txt", Content)
+	}},
+	{"setstat missing file", func(t *testing.T, fsys fs.FS) {
+		expectFailure(t, Setstat(fsys, "/missing.txt", 0600))
+	}},
+	{"rename file", func(t *testing.T, fsys fs.FS) {
+		expectOk(t, Cmd(fsys, "Rename", "/existing.txt", "/dir/renamed.txt"))
+		expectContent(t, fsys, "/dir/renamed.txt", Content)
+		expectMissing(t, fsys, "/existing.txt")
+	}},
+	{"rename missing file", func(t *testing.T, fsys fs.FS) {
+		expectFailure(t, Cmd(fsys, "Rename", "/missing.txt", "/renamed.txt"))
+		expectMissing(t, fsys, "/renamed.txt")
+	}},
+	{"mkdir", func(t *testing.T, fsys fs.FS) {
+		expectOk(t, Cmd(fsys, "Mkdir", "/newdir", ""))
+		expectDir(t, fsys, "/newdir")
+		expectEntries(t, fsys, "/newdir")
+		expectEntries(t, fsys, "/", "dir/", "existing.txt", "newdir/")
+	}},
+	{"mkdir nested", func(t *testing.T, fsys fs.FS) {
+		expectOk(t, Cmd(fsys, "Mkdir", "/a/b", ""))
+		expectDir(t, fsys, "/a")
+		expectDir(t, fsys, "/a/b")
+	}},
+	{"rmdir", func(t *testing.T, fsys fs.FS) {
+		expectOk(t, Cmd(fsys, "Rmdir", "/dir", ""))
+		expectMissing(t, fsys, "/dir")
+		expectMissing(t, fsys, "/dir/inner.txt")
+		expectEntries(t, fsys, "/", "existing.txt")
+	}},
+	{"remove file", func(t *testing.T, fsys fs.FS) {
+		expectOk(t, Cmd(fsys, "Remove", "/existing.txt", ""))
+		expectMissing(t, fsys, "/existing.txt")
+	}},
+	{"remove missing file", func(t *testing.T, fsys fs.FS) {
+		expectFailure(t, Cmd(fsys, "Remove", "/missing.txt", ""))
+	}},
+	// The SFTP server cleans the paths of requests, the file system must not rely on it: paths
+	// climbing above the root either fail or resolve beneath it, as if the root was "/".
+	{"write path escape", func(t *testing.T, fsys fs.FS) {
+		request := Request("Put", "/")
+		request.Filepath = "../../escaped.txt"
+		w, err := fsys.Filewrite(request)
+		if err != nil {
+			expectMissing(t, fsys, "/escaped.txt")
+			return
+		}
+		w.WriteAt([]byte("escaped"), 0)
+		if c, ok := w.(io.Closer); ok {
+			c.Close()
+		}
+		expectContent(t, fsys, "/escaped.txt", "escaped")
+	}},
+	{"read path escape", func(t *testing.T, fsys fs.FS) {
+		request := Request("Get", "/")
+		request.Filepath = "/../existing.txt"
+		if data, err := readRequest(fsys, request); err == nil && string(data) != Content {
+			t.Fatalf("/../existing.txt holds %q, want the content of /existing.txt", data)
+		}
+		request.Filepath = "/../../../etc/passwd"
+		if _, err := readRequest(fsys, request); err == nil {
+			t.Fatal("read a file outside of the root")
+		}
+	}},
+	{"rename path escape", func(t *testing.T, fsys fs.FS) {
+		if err := Cmd(fsys, "Rename", "/existing.txt", "../../escaped.txt"); !Succeeded(err) {
+			expectContent(t, fsys, "/existing.txt", Content)
+			expectMissing(t, fsys, "/escaped.txt")
+			return
+		}
+		expectContent(t, fsys, "/escaped.txt", Content)
+		expectMissing(t, fsys, "/existing.txt")
+	}},
+	// Links are stored relative to where they are created. Renaming the directory holding
+	// one moves it to where it points above the root, reading it must then fail rather than
+	// lead outside. File systems without symlinks skip the scenario.
+	{"symlink then rename", func(t *testing.T, fsys fs.FS) {
+		expectOk(t, Cmd(fsys, "Mkdir", "/a/b", ""))
+		err := Cmd(fsys, "Symlink", "/existing.txt", "/a/b/link")
+		if errors.Is(err, sftp.ErrSshFxOpUnsupported) {
+			t.Skip("symlinks are not supported")
+		}
+		expectOk(t, err)
+		expectContent(t, fsys, "/a/b/link", Content)
+
+		expectOk(t, Cmd(fsys, "Rename", "/a/b", "/b"))
+		expectConfinedContent(t, fsys, "/b/link")
+		Cmd(fsys, "Rename", "/b/link", "/link")
+		expectConfinedContent(t, fsys, "/link")
+		expectConfinedContent(t, fsys, "/b/link")
+		expectContent(t, fsys, "/existing.txt", Content)
+	}},
+	{"mkdir path escape", func(t *testing.T, fsys fs.FS) {
+		request := Request("Mkdir", "/")
+		request.Filepath = "/../../escaped"
+		if err := fsys.Filecmd(request); !Succeeded(err) {
+			expectMissing(t, fsys, "/escaped")
+			return
+		}
+		expectDir(t, fsys, "/escaped")
+	}},
+}
+
+func expectOk(t *testing.T, err error) {
+	t.Helper()
+	if !Succeeded(err) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func expectFailure(t *testing.T, err error) {
+	t.Helper()
+	if Succeeded(err) {
+		t.Fatal("unexpected success")
+	}
+}
+
+func expectError(t *testing.T, err, want error) {
+	t.Helper()
+	if !errors.Is(err, want) {
+		t.Fatalf("got %v, want %v", err, want)
+	}
+}
+
+func expectContent(t *testing.T, fsys fs.FS, p, want string) {
+	t.Helper()
+	data, err := ReadFile(fsys, p)
+	if err != nil {
+		t.Fatalf("reading %s: %v", p, err)
+	}
+	if string(data) != want {
+		t.Fatalf("%s holds %q, want %q", p, data, want)
+	}
+}
+
+// expectConfinedContent checks that p either cannot be read or holds the content of
+// /existing.txt, which a link escaping the root might be mistaken for.
+func expectConfinedContent(t *testing.T, fsys fs.FS, p string) {
+	t.Helper()
+	if data, err := ReadFile(fsys, p); err == nil && string(data) != Content {
+		t.Fatalf("%s holds %q, want the content of /existing.txt", p, data)
+	}
+}
+
+func expectDir(t *testing.T, fsys fs.FS, p string) {
+	t.Helper()
+	info, err := Stat(fsys, p)
+	if err != nil {
+		t.Fatalf("stat %s: %v", p, err)
+	}
+	if !info.IsDir() {
+		t.Fatalf("%s is not a directory", p)
+	}
+}
+
+func expectMissing(t *testing.T, fsys fs.FS, p string) {
+	t.Helper()
+	if _, err := Stat(fsys, p); !errors.Is(err, sftp.ErrSshFxNoSuchFile) {
+		t.Fatalf("stat %s: got %v, want %v", p, err, sftp.ErrSshFxNoSuchFile)
+	}
+}
+
+// expectEntries checks the entries of directory p, directories being suffixed with "/".
+func expectEntries(t *testing.T, fsys fs.FS, p string, want ...string) {
+	t.Helper()
+	infos, err := List(fsys, p)
+	if err != nil {
+		t.Fatalf("listing %s: %v", p, err)
+	}
+	var got []string
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() {
+			name += "/"
+		}
+		got = append(got, name)
+	}
+	slices.Sort(got)
+	if !slices.Equal(got, want) {
+		t.Fatalf("%s lists %s, want %s", p, strings.Join(got, ", "), strings.Join(want, ", "))
+	}
+}
@@ -0,0 +1,350 @@
+// Package fstest runs the same scenarios against every fs.FS implementation, so that backends
+// behave alike towards SFTP clients.
+package fstest
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/pkg/sftp"
+
+	"github.com/oarkflow/ftp-server/fs"
+)
+
+// Factory ... Prepares an empty storage for a test and returns a function creating file
+// systems backed by it, each granted the given permissions.
+type Factory func(t *testing.T) func(permissions []string) fs.FS
+
+// AllPermissions ... Every permission a file system knows of.
+var AllPermissions = []string{fs.Read, fs.ReadContent, fs.Create, fs.Update, fs.Delete}
+
+// Content ... The content of the files the scenarios start with.
+const Content = "hello, world\n"
+
+// attrPermissions ... The SSH_FILEXFER_ATTR_PERMISSIONS flag of a Setstat request.
+const attrPermissions = 0x4
+
+// Request builds an SFTP request, as the SFTP server would hand it to a file system.
+func Request(method, p string) *sftp.Request {
+	return sftp.NewRequest(method, p)
+}
+
+// WriteFile uploads data to p the way a client does: Filewrite, writes, then closing the handle.
+func WriteFile(fsys fs.FS, p string, data []byte) error {
+	w, err := fsys.Filewrite(Request("Put", p))
+	if err != nil {
+		return err
+	}
+	_, err = w.WriteAt(data, 0)
+	if c, ok := w.(io.Closer); ok {
+		if closeErr := c.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// ReadFile downloads p the way a client does: Fileread, reads until the end, then closing
+// the handle.
+func ReadFile(fsys fs.FS, p string) ([]byte, error) {
+	return readRequest(fsys, Request("Get", p))
+}
+
+func readRequest(fsys fs.FS, request *sftp.Request) ([]byte, error) {
+	r, err := fsys.Fileread(request)
+	if err != nil {
+		return nil, err
+	}
+	if c, ok := r.(io.Closer); ok {
+		defer c.Close()
+	}
+	var data []byte
+	buf := make([]byte, 5)
+	for {
+		n, err := r.ReadAt(buf, int64(len(data)))
+		data = append(data, buf[:n]...)
+		if errors.Is(err, io.EOF) || (err == nil && n == 0) {
+			return data, nil
+		}
+		if err != nil {
+			return data, err
+		}
+	}
+}
+
+// Cmd runs a Filecmd request, target being empty for the methods that take none.
+func Cmd(fsys fs.FS, method, p, target string) error {
+	request := Request(method, p)
+	request.Target = target
+	return fsys.Filecmd(request)
+}
+
+// Setstat runs a Setstat request changing the mode of p.
+func Setstat(fsys fs.FS, p string, mode os.FileMode) error {
+	request := Request("Setstat", p)
+	request.Flags = attrPermissions
+	request.Attrs = binary.BigEndian.AppendUint32(nil, uint32(mode))
+	return fsys.Filecmd(request)
+}
+
+// List returns the entries of directory p.
+func List(fsys fs.FS, p string) ([]os.FileInfo, error) {
+	return listAll(fsys, "List", p)
+}
+
+// Stat returns the entry of p.
+func Stat(fsys fs.FS, p string) (os.FileInfo, error) {
+	infos, err := listAll(fsys, "Stat", p)
+	if err != nil {
+		return nil, err
+	}
+	if len(infos) != 1 {
+		return nil, errors.New("stat returned no single entry")
+	}
+	return infos[0], nil
+}
+
+func listAll(fsys fs.FS, method, p string) ([]os.FileInfo, error) {
+	lister, err := fsys.Filelist(Request(method, p))
+	if err != nil {
+		return nil, err
+	}
+	var infos []os.FileInfo
+	for {
+		buf := make([]os.FileInfo, 8)
+		n, err := lister.ListAt(buf, int64(len(infos)))
+		infos = append(infos, buf[:n]...)
+		if errors.Is(err, io.EOF) || (err == nil && n == 0) {
+			return infos, nil
+		}
+		if err != nil {
+			return infos, err
+		}
+	}
+}
+
+// Exists tells whether p is listed in its parent directory.
+func Exists(t *testing.T, fsys fs.FS, p string) bool {
+	t.Helper()
+	infos, err := List(fsys, path.Dir(p))
+	if err != nil {
+		t.Fatalf("listing %s: %v", path.Dir(p), err)
+	}
+	for _, info := range infos {
+		if info.Name() == path.Base(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Succeeded tells whether a Filecmd result reports success, which file systems signal with
+// either nil or ErrSshFxOk.
+func Succeeded(err error) bool {
+	return err == nil || errors.Is(err, sftp.ErrSshFxOk)
+}
+
+// Seed creates the files the scenarios start with: /existing.txt, and the directory /dir
+// holding /dir/inner.txt.
+func Seed(t *testing.T, fsys fs.FS) {
+	t.Helper()
+	for _, p := range []string{"/existing.txt", "/dir/inner.txt"} {
+		if err := WriteFile(fsys, p, []byte(Content)); err != nil {
+			t.Fatalf("seeding %s: %v", p, err)
+		}
+	}
+}
+
+// scenario ... An operation needing a single permission. applied, when set, tells from a file
+// system granted every permission whether the operation took effect.
+type scenario struct {
+	name       string
+	permission string
+	write      bool
+	run        func(fsys fs.FS) error
+	applied    func(t *testing.T, fsys fs.FS) bool
+}
+
+var scenarios = []scenario{
+	{
+		name:       "read file",
+		permission: fs.ReadContent,
+		run: func(fsys fs.FS) error {
+			_, err := ReadFile(fsys, "/existing.txt")
+			return err
+		},
+	},
+	{
+		name:       "list directory",
+		permission: fs.Read,
+		run: func(fsys fs.FS) error {
+			_, err := List(fsys, "/")
+			return err
+		},
+	},
+	{
+		name:       "stat file",
+		permission: fs.Read,
+		run: func(fsys fs.FS) error {
+			_, err := Stat(fsys, "/existing.txt")
+			return err
+		},
+	},
+	{
+		name:       "create file",
+		permission: fs.Create,
+		write:      true,
+		run: func(fsys fs.FS) error {
+			return WriteFile(fsys, "/new.txt", []byte("new"))
+		},
+		applied: func(t *testing.T, fsys fs.FS) bool {
+			return Exists(t, fsys, "/new.txt")
+		},
+	},
+	{
+		name:       "overwrite file",
+		permission: fs.Update,
+		write:      true,
+		run: func(fsys fs.FS) error {
+			return WriteFile(fsys, "/existing.txt", []byte("changed"))
+		},
+		applied: func(t *testing.T, fsys fs.FS) bool {
+			data, err := ReadFile(fsys, "/existing.txt")
+			if err != nil {
+				t.Fatalf("reading /existing.txt: %v", err)
+			}
+			return string(data) == "changed"
+		},
+	},
+	{
+		name:       "setstat",
+		permission: fs.Update,
+		write:      true,
+		run: func(fsys fs.FS) error {
+			return Setstat(fsys, "/existing.txt", 0600)
+		},
+	},
+	{
+		name:       "rename",
+		permission: fs.Update,
+		write:      true,
+		run: func(fsys fs.FS) error {
+			return Cmd(fsys, "Rename", "/existing.txt", "/renamed.txt")
+		},
+		applied: func(t *testing.T, fsys fs.FS) bool {
+			return Exists(t, fsys, "/renamed.txt")
+		},
+	},
+	{
+		name:       "mkdir",
+		permission: fs.Create,
+		write:      true,
+		run: func(fsys fs.FS) error {
+			return Cmd(fsys, "Mkdir", "/newdir", "")
+		},
+		applied: func(t *testing.T, fsys fs.FS) bool {
+			return Exists(t, fsys, "/newdir")
+		},
+	},
+	{
+		name:       "symlink",
+		permission: fs.Create,
+		write:      true,
+		run: func(fsys fs.FS) error {
+			// Filepath is the target of the link and Target the link itself, as the SFTP
+			// server passes them.
+			err := Cmd(fsys, "Symlink", "/existing.txt", "/link.txt")
+			if errors.Is(err, sftp.ErrSshFxOpUnsupported) {
+				// Not every storage has links, what matters is the permission check.
+				return nil
+			}
+			return err
+		},
+	},
+	{
+		name:       "remove file",
+		permission: fs.Delete,
+		write:      true,
+		run: func(fsys fs.FS) error {
+			return Cmd(fsys, "Remove", "/existing.txt", "")
+		},
+		applied: func(t *testing.T, fsys fs.FS) bool {
+			return !Exists(t, fsys, "/existing.txt")
+		},
+	},
+	{
+		name:       "rmdir",
+		permission: fs.Delete,
+		write:      true,
+		run: func(fsys fs.FS) error {
+			return Cmd(fsys, "Rmdir", "/dir", "")
+		},
+		applied: func(t *testing.T, fsys fs.FS) bool {
+			return !Exists(t, fsys, "/dir")
+		},
+	},
+}
+
+// without returns every permission but the given one.
+func without(permission string) []string {
+	var permissions []string
+	for _, p := range AllPermissions {
+		if p != permission {
+			permissions = append(permissions, p)
+		}
+	}
+	return permissions
+}
+
+// TestPermissions checks that every operation is denied without the permission it needs, even
+// when all the others are granted, that it succeeds with every permission, and that read-only
+// mode denies all the writes.
+func TestPermissions(t *testing.T, newFS Factory) {
+	for _, sc := range scenarios {
+		t.Run(sc.name, func(t *testing.T) {
+			t.Run("denied", func(t *testing.T) {
+				storage := newFS(t)
+				admin := storage(AllPermissions)
+				Seed(t, admin)
+				err := sc.run(storage(without(sc.permission)))
+				if !errors.Is(err, sftp.ErrSshFxPermissionDenied) {
+					t.Fatalf("without %s: got %v, want permission denied", sc.permission, err)
+				}
+				if sc.applied != nil && sc.applied(t, admin) {
+					t.Fatal("operation took effect although it was denied")
+				}
+			})
+			t.Run("granted", func(t *testing.T) {
+				storage := newFS(t)
+				admin := storage(AllPermissions)
+				Seed(t, admin)
+				if err := sc.run(storage([]string{sc.permission})); !Succeeded(err) {
+					t.Fatalf("with %s: %v", sc.permission, err)
+				}
+				if sc.applied != nil && !sc.applied(t, admin) {
+					t.Fatal("operation did not take effect")
+				}
+			})
+			if !sc.write {
+				return
+			}
+			t.Run("read-only", func(t *testing.T) {
+				storage := newFS(t)
+				admin := storage(AllPermissions)
+				Seed(t, admin)
+				fsys := storage(AllPermissions)
+				fsys.SetReadOnly(true)
+				if err := sc.run(fsys); !errors.Is(err, sftp.ErrSshFxPermissionDenied) {
+					t.Fatalf("in read-only mode: got %v, want permission denied", err)
+				}
+				if sc.applied != nil && sc.applied(t, admin) {
+					t.Fatal("operation took effect in read-only mode")
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,21 @@
+// Package mem provides a filesystem held in memory, for tests and ephemeral drop boxes. It
+// applies the same permissions as the OS backed filesystem.
+package mem
+
+import (
+	"github.com/oarkflow/ftp-server/fs"
+	"github.com/oarkflow/ftp-server/fs/aferofs"
+)
+
+// Mem ... A filesystem held in a Store, served by the afero adapter.
+type Mem = aferofs.Fs
+
+// New creates a filesystem on store, or on a store of its own, without size limit, when store
+// is nil. The options of aferofs apply as well.
+func New(store *Store, opts ...func(*Mem)) fs.FS {
+	if store == nil {
+		store = NewStore(0)
+	}
+	opts = append([]func(*Mem){aferofs.WithType("memory"), aferofs.WithLockManager(store.locks)}, opts...)
+	return aferofs.New(store.fs, opts...)
+}
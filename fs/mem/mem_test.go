@@ -0,0 +1,77 @@
+package mem_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/oarkflow/ftp-server/errs"
+	"github.com/oarkflow/ftp-server/fs"
+	"github.com/oarkflow/ftp-server/fs/fstest"
+	"github.com/oarkflow/ftp-server/fs/mem"
+	"github.com/oarkflow/ftp-server/log/oarklog"
+)
+
+func newFS(t *testing.T) func(permissions []string) fs.FS {
+	store := mem.NewStore(0)
+	return func(permissions []string) fs.FS {
+		fsys := mem.New(store, mem.WithPermissions(permissions))
+		fsys.SetLogger(oarklog.Default())
+		return fsys
+	}
+}
+
+func TestFS(t *testing.T) {
+	fstest.Run(t, newFS)
+}
+
+func TestMaxSize(t *testing.T) {
+	store := mem.NewStore(10)
+	fsys := mem.New(store, mem.WithPermissions(fstest.AllPermissions))
+	fsys.SetLogger(oarklog.Default())
+
+	if err := fstest.WriteFile(fsys, "/a.txt", []byte("12345678")); err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.WriteFile(fsys, "/b.txt", []byte("123")); !errors.Is(err, errs.ErrSSHQuotaExceeded) {
+		t.Fatalf("got %v, want %v", err, errs.ErrSSHQuotaExceeded)
+	}
+	// Overwriting a file frees its previous content.
+	if err := fstest.WriteFile(fsys, "/a.txt", []byte("1234567890")); err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.Cmd(fsys, "Rename", "/a.txt", "/dir/a.txt"); !fstest.Succeeded(err) {
+		t.Fatal(err)
+	}
+	if used := store.Used(); used != 10 {
+		t.Fatalf("store holds %d bytes, want 10", used)
+	}
+	if err := fstest.Cmd(fsys, "Rmdir", "/dir", ""); !fstest.Succeeded(err) {
+		t.Fatal(err)
+	}
+	if used := store.Used(); used != 0 {
+		t.Fatalf("store holds %d bytes after removing everything, want 0", used)
+	}
+	if err := fstest.WriteFile(fsys, "/b.txt", []byte("123")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSharedStore(t *testing.T) {
+	stores := mem.NewStores()
+	first := mem.New(stores.Get("drop", 0), mem.WithPermissions(fstest.AllPermissions))
+	second := mem.New(stores.Get("drop", 0), mem.WithPermissions(fstest.AllPermissions))
+	other := mem.New(stores.Get("other", 0), mem.WithPermissions(fstest.AllPermissions))
+	for _, fsys := range []fs.FS{first, second, other} {
+		fsys.SetLogger(oarklog.Default())
+	}
+
+	if err := fstest.WriteFile(first, "/file.txt", []byte(fstest.Content)); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := fstest.ReadFile(second, "/file.txt"); err != nil || string(data) != fstest.Content {
+		t.Fatalf("reading from the same store: %q, %v", data, err)
+	}
+	if _, err := fstest.ReadFile(other, "/file.txt"); err == nil {
+		t.Fatal("read a file of another store")
+	}
+}
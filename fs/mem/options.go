@@ -0,0 +1,11 @@
+package mem
+
+import "github.com/oarkflow/ftp-server/fs/aferofs"
+
+func WithPermissions(val []string) func(f *Mem) {
+	return aferofs.WithPermissions(val)
+}
+
+func WithReadOnly(val bool) func(f *Mem) {
+	return aferofs.WithReadOnly(val)
+}
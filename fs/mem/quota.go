@@ -0,0 +1,97 @@
+package mem
+
+import (
+	"io"
+	"os"
+	"path"
+
+	"github.com/spf13/afero"
+)
+
+// quotaFs ... An afero.Fs accounting in its store for the content of every file written,
+// truncated, moved or removed through it.
+type quotaFs struct {
+	afero.Fs
+	store *Store
+}
+
+// cleanPath returns the path the store accounts the file name under.
+func cleanPath(name string) string {
+	return path.Clean("/" + name)
+}
+
+func (q *quotaFs) Create(name string) (afero.File, error) {
+	return q.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (q *quotaFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	fl, err := q.Fs.OpenFile(name, flag, perm)
+	if err != nil || flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return fl, err
+	}
+	p := cleanPath(name)
+	if flag&os.O_TRUNC != 0 {
+		q.store.resize(p, 0)
+	}
+	return &quotaFile{File: fl, store: q.store, path: p}, nil
+}
+
+func (q *quotaFs) Remove(name string) error {
+	if err := q.Fs.Remove(name); err != nil {
+		return err
+	}
+	q.store.removed(cleanPath(name))
+	return nil
+}
+
+func (q *quotaFs) RemoveAll(name string) error {
+	if err := q.Fs.RemoveAll(name); err != nil {
+		return err
+	}
+	q.store.removed(cleanPath(name))
+	return nil
+}
+
+func (q *quotaFs) Rename(oldname, newname string) error {
+	if err := q.Fs.Rename(oldname, newname); err != nil {
+		return err
+	}
+	q.store.renamed(cleanPath(oldname), cleanPath(newname))
+	return nil
+}
+
+// quotaFile ... A file open for writing, which may not grow the store beyond its maximum size.
+type quotaFile struct {
+	afero.File
+	store *Store
+	path  string
+}
+
+func (f *quotaFile) Write(b []byte) (int, error) {
+	offset, err := f.File.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if err := f.store.grow(f.path, offset+int64(len(b))); err != nil {
+		return 0, err
+	}
+	return f.File.Write(b)
+}
+
+func (f *quotaFile) WriteAt(b []byte, offset int64) (int, error) {
+	if err := f.store.grow(f.path, offset+int64(len(b))); err != nil {
+		return 0, err
+	}
+	return f.File.WriteAt(b, offset)
+}
+
+func (f *quotaFile) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+func (f *quotaFile) Truncate(size int64) error {
+	if err := f.store.resize(f.path, size); err != nil {
+		return err
+	}
+	return f.File.Truncate(size)
+}
@@ -0,0 +1,132 @@
+package mem
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/spf13/afero"
+
+	"github.com/oarkflow/ftp-server/errs"
+	"github.com/oarkflow/ftp-server/fs/afos"
+)
+
+// Store ... The files of one or more memory filesystems, which are lost when the process exits.
+// Filesystems created on the same store share their files.
+type Store struct {
+	// fs keeps track of the size of the files written through it.
+	fs      afero.Fs
+	locks   *afos.LockManager
+	maxSize int64
+
+	mu    sync.Mutex
+	sizes map[string]int64
+	used  int64
+}
+
+// NewStore creates an empty store holding at most maxSize bytes of file content, or any amount
+// when maxSize is 0.
+func NewStore(maxSize int64) *Store {
+	s := &Store{
+		locks:   afos.NewLockManager(),
+		maxSize: maxSize,
+		sizes:   make(map[string]int64),
+	}
+	s.fs = &quotaFs{Fs: afero.NewMemMapFs(), store: s}
+	return s
+}
+
+// Used returns the number of bytes of file content held by the store.
+func (s *Store) Used() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.used
+}
+
+// MaxSize returns the number of bytes the store may hold, 0 meaning no limit.
+func (s *Store) MaxSize() int64 {
+	return s.maxSize
+}
+
+// grow accounts for the file at p being written up to end, failing with
+// errs.ErrSSHQuotaExceeded when the store would hold more than its maximum size.
+func (s *Store) grow(p string, end int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if end <= s.sizes[p] {
+		return nil
+	}
+	return s.resizeLocked(p, end)
+}
+
+// resize accounts for the file at p being truncated or extended to size.
+func (s *Store) resize(p string, size int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.resizeLocked(p, size)
+}
+
+func (s *Store) resizeLocked(p string, size int64) error {
+	growth := size - s.sizes[p]
+	if growth > 0 && s.maxSize > 0 && s.used+growth > s.maxSize {
+		return errs.ErrSSHQuotaExceeded
+	}
+	s.used += growth
+	s.sizes[p] = size
+	return nil
+}
+
+// removed accounts for p being removed, along with everything beneath it.
+func (s *Store) removed(p string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, size := range s.sizes {
+		if name == p || strings.HasPrefix(name, strings.TrimSuffix(p, "/")+"/") {
+			s.used -= size
+			delete(s.sizes, name)
+		}
+	}
+}
+
+// renamed accounts for p being moved to target, replacing whatever file was there.
+func (s *Store) renamed(p, target string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.used -= s.sizes[target]
+	delete(s.sizes, target)
+	moved := make(map[string]int64)
+	for name, size := range s.sizes {
+		if name == p || strings.HasPrefix(name, p+"/") {
+			delete(s.sizes, name)
+			moved[target+strings.TrimPrefix(name, p)] = size
+		}
+	}
+	for name, size := range moved {
+		s.sizes[name] = size
+	}
+}
+
+// Stores ... Stores shared by name, typically by every session of a server so that the
+// filesystems of users naming the same store see the same files.
+type Stores struct {
+	mu     sync.Mutex
+	stores map[string]*Store
+}
+
+// NewStores creates an empty set of stores.
+func NewStores() *Stores {
+	return &Stores{stores: make(map[string]*Store)}
+}
+
+// Get returns the store called name, creating it with the given maximum size if it does not
+// exist yet. A store keeps the maximum size it was created with, callers asking for another one
+// can tell from its MaxSize.
+func (s *Stores) Get(name string, maxSize int64) *Store {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	store, exists := s.stores[name]
+	if !exists {
+		store = NewStore(maxSize)
+		s.stores[name] = store
+	}
+	return store
+}
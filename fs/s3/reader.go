@@ -4,18 +4,27 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"os"
+	"path"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
 type reader struct {
+	ctx    context.Context
 	object *s3.GetObjectOutput
 	client *s3.Client
 	key    string
 	bucket string
 }
 
+// Stat describes the object being read, it lets the server tell whether a download read all
+// of it.
+func (reader reader) Stat() (os.FileInfo, error) {
+	return NewFileInfo(path.Base(reader.key), false, aws.ToInt64(reader.object.ContentLength), aws.ToTime(reader.object.LastModified)), nil
+}
+
 func (reader reader) ReadAt(buffer []byte, offset int64) (int, error) {
 	// Ensure the requested range falls within the bounds of the object's content length
 	if offset < 0 || offset >= *reader.object.ContentLength {
@@ -39,7 +48,7 @@ func (reader reader) ReadAt(buffer []byte, offset int64) (int, error) {
 	}
 
 	// Send request to S3 to get the specified range of bytes
-	resp, err := reader.client.GetObject(context.Background(), input)
+	resp, err := reader.client.GetObject(reader.ctx, input)
 	if err != nil {
 		return 0, err
 	}
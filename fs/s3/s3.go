@@ -4,12 +4,19 @@ import (
 	"context"
 	"io"
 	"os"
+	"path"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
 	"github.com/pkg/sftp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 	"golang.org/x/crypto/ssh"
 
 	"github.com/oarkflow/ftp-server/log"
@@ -43,15 +50,18 @@ func (f *Fs) Fileread(request *sftp.Request) (io.ReaderAt, error) {
 	}
 	switch request.Method {
 	case "Get":
-		key := strings.TrimPrefix(request.Filepath, "/")
-		object, err := f.client.GetObject(context.Background(), &s3.GetObjectInput{
+		key := objectKey(request.Filepath)
+		object, err := f.client.GetObject(request.Context(), &s3.GetObjectInput{
 			Bucket: aws.String(f.bucket),
 			Key:    aws.String(key),
 		})
-		if err != nil {
-			return nil, err
+		if isNotFound(err) {
+			return nil, sftp.ErrSshFxNoSuchFile
+		} else if err != nil {
+			f.logger.Error("could not open file for reading", "source", key, "err", err)
+			return nil, sftp.ErrSshFxFailure
 		}
-		return reader{object: object, client: f.client, key: key, bucket: f.bucket}, nil
+		return reader{ctx: request.Context(), object: object, client: f.client, key: key, bucket: f.bucket}, nil
 	default:
 		return nil, sftp.ErrSSHFxOpUnsupported
 	}
@@ -59,22 +69,59 @@ func (f *Fs) Fileread(request *sftp.Request) (io.ReaderAt, error) {
 
 func (f *Fs) Filewrite(request *sftp.Request) (io.WriterAt, error) {
 	if f.readOnly {
-		return nil, sftp.ErrSshFxOpUnsupported
+		return nil, sftp.ErrSshFxPermissionDenied
 	}
 	switch request.Method {
 	case "Put":
-		return newWriter(context.Background(), f.client, f.bucket, strings.TrimPrefix(request.Filepath, "/"))
+		key := objectKey(request.Filepath)
+		if err := f.checkWrite(request.Context(), key); err != nil {
+			return nil, err
+		}
+		return newWriter(request.Context(), f.client, f.bucket, key)
 	default:
 		return nil, sftp.ErrSSHFxOpUnsupported
 	}
 }
 
+// checkWrite checks the permission an upload to key needs, create for a new object and update
+// for an existing one, like afos does for files.
+func (f *Fs) checkWrite(ctx context.Context, key string) error {
+	canCreate, canUpdate := fs.Can(f.permissions, fs.Create), fs.Can(f.permissions, fs.Update)
+	if canCreate && canUpdate {
+		return nil
+	}
+	if !canCreate && !canUpdate {
+		return sftp.ErrSshFxPermissionDenied
+	}
+	_, err := f.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(f.bucket),
+		Key:    aws.String(key),
+	})
+	switch {
+	case err == nil:
+		if !canUpdate {
+			return sftp.ErrSshFxPermissionDenied
+		}
+	case isNotFound(err):
+		if !canCreate {
+			return sftp.ErrSshFxPermissionDenied
+		}
+	default:
+		f.logger.Error("error performing file stat", "source", key, "err", err)
+		return sftp.ErrSshFxFailure
+	}
+	return nil
+}
+
 func (f *Fs) Filecmd(request *sftp.Request) error {
 	if f.readOnly {
-		return sftp.ErrSshFxOpUnsupported
+		return sftp.ErrSshFxPermissionDenied
 	}
-	p := request.Filepath
+	p := cleanPath(request.Filepath)
 	target := request.Target
+	if target != "" {
+		target = cleanPath(target)
+	}
 	switch request.Method {
 	case "Setstat":
 		if !fs.Can(f.permissions, fs.Update) {
@@ -135,6 +182,13 @@ func (f *Fs) Filecmd(request *sftp.Request) error {
 		}
 
 		break
+	case "Symlink":
+		if !fs.Can(f.permissions, fs.Create) {
+			return sftp.ErrSshFxPermissionDenied
+		}
+
+		// S3 has no notion of links.
+		return sftp.ErrSshFxOpUnsupported
 	case "Remove":
 		if !fs.Can(f.permissions, fs.Delete) {
 			return sftp.ErrSshFxPermissionDenied
@@ -155,18 +209,25 @@ func (f *Fs) Filecmd(request *sftp.Request) error {
 }
 
 func (f *Fs) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
-	p := request.Filepath
+	p := cleanPath(request.Filepath)
 	switch request.Method {
 	case "List":
 		if !fs.Can(f.permissions, fs.Read) {
 			return nil, sftp.ErrSshFxPermissionDenied
 		}
 		file := NewFile(f, p)
+		file.ctx = request.Context()
 		files, err := file.ReaddirAll()
 		if err != nil {
 			f.logger.Error("error listing directory", "err", err)
 			return nil, sftp.ErrSshFxFailure
 		}
+		// Directories only exist as key prefixes, an empty listing may be that of a missing one.
+		if len(files) == 0 && p != "/" {
+			if _, err := f.Stat(p + "/"); os.IsNotExist(err) {
+				return nil, sftp.ErrSshFxNoSuchFile
+			}
+		}
 
 		return fs.ListerAt(files), nil
 	case "Stat":
@@ -193,6 +254,16 @@ func (f *Fs) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
 	}
 }
 
+// cleanPath resolves p as if the bucket was the root, so that ".." can never climb above it.
+func cleanPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+// objectKey returns the key of the object at p.
+func objectKey(p string) string {
+	return strings.TrimPrefix(cleanPath(p), "/")
+}
+
 func (f *Fs) SetLogger(logger log.Logger) {
 	f.logger = logger
 }
@@ -205,6 +276,14 @@ func (f *Fs) Permissions() []string {
 	return fs.Deserialize(f.permissions)
 }
 
+func (f *Fs) SetReadOnly(readOnly bool) {
+	f.readOnly = readOnly
+}
+
+func (f *Fs) ReadOnly() bool {
+	return f.readOnly
+}
+
 func (f *Fs) SetID(p string) {
 	f.id = p
 }
@@ -219,6 +298,12 @@ type Option struct {
 	Bucket    string `json:"bucket"`
 	AccessKey string `json:"access_key"`
 	Secret    string `json:"secret"`
+	// Observer, when set, is called after every S3 API call with the name of the operation
+	// and the error it returned, if any.
+	Observer func(operation string, err error) `json:"-"`
+	// Tracer, when set, records a client span for every S3 API call, as a child of the span
+	// carried by the context of the SFTP request that triggered it.
+	Tracer trace.Tracer `json:"-"`
 }
 
 func New(opt Option) (fs.FS, error) {
@@ -234,7 +319,43 @@ func New(opt Option) (fs.FS, error) {
 			}, nil
 		}),
 	}
+	if opt.Observer != nil || opt.Tracer != nil {
+		conf.APIOptions = append(conf.APIOptions, instrument(opt))
+	}
 
 	s3Fs := NewFsFromConfig(opt.Bucket, conf)
 	return s3Fs, nil
 }
+
+// instrument adds a middleware tracing each API call and reporting its outcome to the observer,
+// once any retries are done.
+func instrument(opt Option) func(*middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("SFTPInstrumentation",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				operation := awsmiddleware.GetOperationName(ctx)
+				var span trace.Span = noop.Span{}
+				if opt.Tracer != nil {
+					ctx, span = opt.Tracer.Start(ctx, "S3."+operation,
+						trace.WithSpanKind(trace.SpanKindClient),
+						trace.WithAttributes(
+							attribute.String("rpc.system", "aws-api"),
+							attribute.String("rpc.service", "S3"),
+							attribute.String("rpc.method", operation),
+							attribute.String("aws.s3.bucket", opt.Bucket),
+						),
+					)
+				}
+				out, metadata, err := next.HandleInitialize(ctx, in)
+				if err != nil {
+					span.RecordError(err)
+					span.SetStatus(codes.Error, err.Error())
+				}
+				span.End()
+				if opt.Observer != nil {
+					opt.Observer(operation, err)
+				}
+				return out, metadata, err
+			}), middleware.After)
+	}
+}
@@ -22,16 +22,17 @@ import (
 
 // File represents a file in S3.
 type File struct {
-	cachedInfo               os.FileInfo    // File info cached for later used
-	streamRead               io.ReadCloser  // streamRead is the underlying stream we are reading from
-	streamWrite              io.WriteCloser // streamWrite is the underlying stream we are reading to
-	streamWriteErr           error          // streamWriteErr is the error that should be returned in case of a write
-	fs                       *Fs            // Parent file system
-	streamWriteCloseErr      chan error     // streamWriteCloseErr is the channel containing the underlying write error
-	readdirContinuationToken *string        // readdirContinuationToken is used to perform files listing across calls
-	name                     string         // Name of the file
-	streamReadOffset         int64          // streamReadOffset is the offset of the read-only stream
-	readdirNotTruncated      bool           // readdirNotTruncated is set when we shall continue reading
+	cachedInfo               os.FileInfo     // File info cached for later used
+	streamRead               io.ReadCloser   // streamRead is the underlying stream we are reading from
+	streamWrite              io.WriteCloser  // streamWrite is the underlying stream we are reading to
+	streamWriteErr           error           // streamWriteErr is the error that should be returned in case of a write
+	fs                       *Fs             // Parent file system
+	streamWriteCloseErr      chan error      // streamWriteCloseErr is the channel containing the underlying write error
+	readdirContinuationToken *string         // readdirContinuationToken is used to perform files listing across calls
+	name                     string          // Name of the file
+	streamReadOffset         int64           // streamReadOffset is the offset of the read-only stream
+	readdirNotTruncated      bool            // readdirNotTruncated is set when we shall continue reading
+	ctx                      context.Context // ctx is the context of the listing, if any
 	// I think readdirNotTruncated can be dropped. The continuation token is probably enough.
 }
 
@@ -75,7 +76,7 @@ func (f *File) Readdir(n int) ([]os.FileInfo, error) {
 	if name != "" && !strings.HasSuffix(name, "/") {
 		name += "/"
 	}
-	output, err := f.fs.client.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{
+	output, err := f.fs.client.ListObjectsV2(f.context(), &s3.ListObjectsV2Input{
 		ContinuationToken: f.readdirContinuationToken,
 		Bucket:            aws.String(f.fs.bucket),
 		Prefix:            &name,
@@ -107,6 +108,14 @@ func (f *File) Readdir(n int) ([]os.FileInfo, error) {
 	return fis, nil
 }
 
+// context returns the context S3 calls made for the file are bound to.
+func (f *File) context() context.Context {
+	if f.ctx != nil {
+		return f.ctx
+	}
+	return context.Background()
+}
+
 // ReaddirAll provides list of file cachedInfo.
 func (f *File) ReaddirAll() ([]os.FileInfo, error) {
 	var fileInfos []os.FileInfo
@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"mime"
+	"net/http"
 	"os"
 	"path"
 	"path/filepath"
@@ -14,6 +15,7 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/spf13/afero"
@@ -265,6 +267,9 @@ func (fs *Fs) Stat(name string) (os.FileInfo, error) {
 	if err != nil {
 		// if it is a not found error, then we try to treat it as a directory
 		// before we give up.
+		if isNotFound(err) {
+			return fs.statDirectory(name + "/")
+		}
 		return FileInfo{}, &os.PathError{
 			Op:   "stat",
 			Path: name,
@@ -302,6 +307,15 @@ func (fs *Fs) statDirectory(name string) (os.FileInfo, error) {
 	return NewFileInfo(path.Base(name), true, 0, time.Unix(0, 0)), nil
 }
 
+// isNotFound tells whether err reports a missing object.
+func isNotFound(err error) bool {
+	var notFound *types.NotFound
+	var noSuchKey *types.NoSuchKey
+	var response *awshttp.ResponseError
+	return errors.As(err, &notFound) || errors.As(err, &noSuchKey) ||
+		(errors.As(err, &response) && response.HTTPStatusCode() == http.StatusNotFound)
+}
+
 // Chmod doesn't exists in S3 but could be implemented by analyzing ACLs
 func (fs *Fs) Chmod(name string, mode os.FileMode) error {
 	name = sanitize(name)
@@ -0,0 +1,35 @@
+package s3_test
+
+import (
+	"testing"
+
+	"github.com/oarkflow/ftp-server/fs"
+	"github.com/oarkflow/ftp-server/fs/fstest"
+	"github.com/oarkflow/ftp-server/fs/s3"
+	"github.com/oarkflow/ftp-server/fs/s3/s3test"
+	"github.com/oarkflow/ftp-server/log/oarklog"
+)
+
+func newFS(t *testing.T) func(permissions []string) fs.FS {
+	srv := s3test.NewServer()
+	t.Cleanup(srv.Close)
+	return func(permissions []string) fs.FS {
+		fsys, err := s3.New(s3.Option{
+			Endpoint:  srv.URL,
+			Region:    "us-east-1",
+			Bucket:    "test",
+			AccessKey: "key",
+			Secret:    "secret",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		fsys.SetPermissions(permissions)
+		fsys.SetLogger(oarklog.Default())
+		return fsys
+	}
+}
+
+func TestFS(t *testing.T) {
+	fstest.Run(t, newFS)
+}
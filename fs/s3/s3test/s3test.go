@@ -0,0 +1,301 @@
+// Package s3test provides an in-memory stand-in for the S3 API, so that the s3 backend can be
+// tested offline. It implements the calls the backend makes, with path-style addressing and
+// buckets created on first use, and does not check signatures.
+package s3test
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server ... An HTTP server answering S3 API calls from memory.
+type Server struct {
+	*httptest.Server
+	mu      sync.Mutex
+	buckets map[string]map[string]*object
+}
+
+// object ... A stored object.
+type object struct {
+	data    []byte
+	etag    string
+	modTime time.Time
+}
+
+// NewServer starts a server, to be closed with Close.
+func NewServer() *Server {
+	s := &Server{buckets: make(map[string]map[string]*object)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Object returns the content of an object.
+func (s *Server) Object(bucket, key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.buckets[bucket][key]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), o.data...), true
+}
+
+// PutObject stores an object, e.g. to prepare a test.
+func (s *Server) PutObject(bucket, key string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(bucket, key, append([]byte(nil), data...))
+}
+
+// Keys returns the keys of the objects in a bucket, sorted.
+func (s *Server) Keys(bucket string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.buckets[bucket]))
+	for key := range s.buckets[bucket] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *Server) put(bucket, key string, data []byte) *object {
+	if s.buckets[bucket] == nil {
+		s.buckets[bucket] = make(map[string]*object)
+	}
+	sum := md5.Sum(data)
+	o := &object{data: data, etag: `"` + hex.EncodeToString(sum[:]) + `"`, modTime: time.Now().UTC().Truncate(time.Second)}
+	s.buckets[bucket][key] = o
+	return o
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket == "" {
+		writeError(w, http.StatusNotImplemented, "NotImplemented", "listing buckets is not supported")
+		return
+	}
+	query := r.URL.Query()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case key == "" && r.Method == http.MethodGet && query.Get("list-type") == "2":
+		s.listObjects(w, bucket, query)
+	case key == "":
+		writeError(w, http.StatusNotImplemented, "NotImplemented", r.Method+" on a bucket is not supported")
+	case query.Has("acl") && r.Method == http.MethodPut:
+		if _, ok := s.buckets[bucket][key]; !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		s.copyObject(w, r, bucket, key)
+	case r.Method == http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+			return
+		}
+		o := s.put(bucket, key, data)
+		w.Header().Set("ETag", o.etag)
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		s.getObject(w, r, bucket, key)
+	case r.Method == http.MethodDelete:
+		delete(s.buckets[bucket], key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented", r.Method+" is not supported")
+	}
+}
+
+func (s *Server) getObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	o, ok := s.buckets[bucket][key]
+	if !ok {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+	data, status := o.data, http.StatusOK
+	if spec := r.Header.Get("Range"); spec != "" && r.Method == http.MethodGet {
+		start, end, ok := parseRange(spec, int64(len(o.data)))
+		if !ok {
+			writeError(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range is not satisfiable")
+			return
+		}
+		data, status = o.data[start:end+1], http.StatusPartialContent
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(o.data)))
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Last-Modified", o.modTime.Format(http.TimeFormat))
+	w.Header().Set("ETag", o.etag)
+	w.WriteHeader(status)
+	if r.Method == http.MethodGet {
+		w.Write(data)
+	}
+}
+
+// parseRange parses a single "bytes=start-end" range, end being inclusive.
+func parseRange(spec string, size int64) (int64, int64, bool) {
+	first, last, ok := strings.Cut(strings.TrimPrefix(spec, "bytes="), "-")
+	if !ok {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false
+		}
+		end = min(end, size-1)
+	}
+	return start, end, true
+}
+
+func (s *Server) copyObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	source, err := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", "invalid copy source")
+		return
+	}
+	srcBucket, srcKey, _ := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+	src, ok := s.buckets[srcBucket][srcKey]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+	o := s.put(bucket, key, append([]byte(nil), src.data...))
+	writeXML(w, struct {
+		XMLName      xml.Name `xml:"CopyObjectResult"`
+		LastModified string
+		ETag         string
+	}{LastModified: o.modTime.Format(time.RFC3339), ETag: o.etag})
+}
+
+type listContent struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int
+	StorageClass string
+}
+
+type listPrefix struct {
+	Prefix string
+}
+
+func (s *Server) listObjects(w http.ResponseWriter, bucket string, query url.Values) {
+	prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+	maxKeys := 1000
+	if v := query.Get("max-keys"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			maxKeys = n
+		}
+	}
+	keys := make([]string, 0, len(s.buckets[bucket]))
+	for key := range s.buckets[bucket] {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	// Entries are keys and common prefixes in key order. The continuation token is the last
+	// entry returned, the listing resumes after it.
+	type entry struct {
+		key    string
+		prefix bool
+	}
+	var entries []entry
+	for _, key := range keys {
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				common := key[:len(prefix)+i+len(delimiter)]
+				if len(entries) == 0 || entries[len(entries)-1].key != common {
+					entries = append(entries, entry{key: common, prefix: true})
+				}
+				continue
+			}
+		}
+		entries = append(entries, entry{key: key})
+	}
+	if token := query.Get("continuation-token"); token != "" {
+		after := sort.Search(len(entries), func(i int) bool { return entries[i].key > token })
+		entries = entries[after:]
+	}
+	truncated := len(entries) > maxKeys
+	if truncated {
+		entries = entries[:maxKeys]
+	}
+
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Name                  string
+		Prefix                string
+		Delimiter             string `xml:",omitempty"`
+		MaxKeys               int
+		KeyCount              int
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+		Contents              []listContent
+		CommonPrefixes        []listPrefix
+	}{Name: bucket, Prefix: prefix, Delimiter: delimiter, MaxKeys: maxKeys, KeyCount: len(entries), IsTruncated: truncated}
+	for _, e := range entries {
+		if e.prefix {
+			result.CommonPrefixes = append(result.CommonPrefixes, listPrefix{Prefix: e.key})
+			continue
+		}
+		o := s.buckets[bucket][e.key]
+		result.Contents = append(result.Contents, listContent{
+			Key:          e.key,
+			LastModified: o.modTime.Format(time.RFC3339),
+			ETag:         o.etag,
+			Size:         len(o.data),
+			StorageClass: "STANDARD",
+		})
+	}
+	if truncated {
+		result.NextContinuationToken = entries[len(entries)-1].key
+	}
+	writeXML(w, result)
+}
+
+func writeXML(w http.ResponseWriter, v any) {
+	data, err := xml.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(data)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+		Message string
+	}{Code: code, Message: message})
+}
@@ -0,0 +1,264 @@
+package ftpserver
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Host key types ... The kinds of host keys the server generates when they are missing.
+const (
+	HostKeyEd25519 = "ed25519"
+	HostKeyECDSA   = "ecdsa"
+	HostKeyRSA     = "rsa"
+)
+
+// DefaultHostKeyTypes ... The host keys used unless WithHostKeyTypes says otherwise, in order of
+// preference.
+var DefaultHostKeyTypes = []string{HostKeyEd25519, HostKeyECDSA, HostKeyRSA}
+
+// HostKey ... A host key of the server.
+type HostKey struct {
+	// Type is the host key type, e.g. HostKeyEd25519, empty for keys configured by file
+	// that do not exist.
+	Type string
+	File string
+	// PublicKey is nil when the key has not been generated yet.
+	PublicKey ssh.PublicKey
+}
+
+// HostKeys lists the host keys of every listener, without generating the missing ones.
+func (c *Server) HostKeys() ([]HostKey, error) {
+	var keys []HostKey
+	seen := make(map[string]bool)
+	add := func(keyType, file string) error {
+		if seen[file] {
+			return nil
+		}
+		seen[file] = true
+		key := HostKey{Type: keyType, File: file}
+		signer, err := loadHostKey(file)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if signer != nil {
+			key.PublicKey = signer.PublicKey()
+		}
+		keys = append(keys, key)
+		return nil
+	}
+	for _, l := range c.listenerConfigs() {
+		for _, keyType := range l.HostKeyTypes {
+			privateName, _ := c.hostKeyFile(keyType)
+			if err := add(keyType, c.getSSHPath(privateName)); err != nil {
+				return nil, err
+			}
+		}
+		for _, file := range l.HostKeyFiles {
+			if err := add("", file); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return keys, nil
+}
+
+// GenerateHostKey creates the host key of the given type in the SSH directory, along with its
+// public key. An existing key is only replaced when replace is set.
+func (c *Server) GenerateHostKey(keyType string, replace bool) (HostKey, error) {
+	if !slices.Contains(DefaultHostKeyTypes, keyType) {
+		return HostKey{}, fmt.Errorf("unknown host key type %q", keyType)
+	}
+	privateName, publicName := c.hostKeyFile(keyType)
+	privatePath := c.getSSHPath(privateName)
+	generate := generateHostKey
+	if replace {
+		generate = replaceHostKey
+	}
+	if err := generate(keyType, privatePath); err != nil {
+		return HostKey{}, err
+	}
+	signer, err := loadHostKey(privatePath)
+	if err != nil {
+		return HostKey{}, err
+	}
+	if err := c.generatePublicKey(signer, c.getSSHPath(publicName)); err != nil {
+		return HostKey{}, err
+	}
+	return HostKey{Type: keyType, File: privatePath, PublicKey: signer.PublicKey()}, nil
+}
+
+// hostKeyFile returns the names of the private and public key files of a key type, in the SSH
+// directory. RSA keys keep the names set by WithPrivateKey and WithPublicKey, so existing
+// installations keep their host key.
+func (c *Server) hostKeyFile(keyType string) (string, string) {
+	if keyType == HostKeyRSA {
+		return c.privateKey, c.publicKey
+	}
+	name := "id_" + keyType
+	return name, name + ".pub"
+}
+
+// loadHostKeys loads the host key of every configured type, generating the ones that do not
+// exist yet, and logs their fingerprints.
+func (c *Server) loadHostKeys(keyTypes []string) ([]ssh.Signer, error) {
+	signers := make([]ssh.Signer, 0, len(keyTypes))
+	for _, keyType := range keyTypes {
+		privateName, publicName := c.hostKeyFile(keyType)
+		privatePath := c.getSSHPath(privateName)
+		if _, err := os.Stat(privatePath); os.IsNotExist(err) {
+			if err := generateHostKey(keyType, privatePath); err != nil {
+				return nil, err
+			}
+			c.logger.Info("Generated host key", "type", keyType, "file", privatePath)
+		} else if err != nil {
+			return nil, err
+		}
+		signer, err := loadHostKey(privatePath)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.generatePublicKey(signer, c.getSSHPath(publicName)); err != nil {
+			return nil, err
+		}
+		c.logHostKey(signer, privatePath)
+		signers = append(signers, signer)
+	}
+	return signers, nil
+}
+
+// loadHostKeyFile loads an existing host key, writing its public key next to it if missing.
+func (c *Server) loadHostKeyFile(file string) (ssh.Signer, error) {
+	signer, err := loadHostKey(file)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(file + ".pub"); os.IsNotExist(err) {
+		if err := c.generatePublicKey(signer, file+".pub"); err != nil {
+			return nil, err
+		}
+	}
+	c.logHostKey(signer, file)
+	return signer, nil
+}
+
+func (c *Server) logHostKey(signer ssh.Signer, file string) {
+	c.logger.Info("Host key loaded",
+		"type", signer.PublicKey().Type(),
+		"fingerprint", ssh.FingerprintSHA256(signer.PublicKey()),
+		"file", file,
+	)
+}
+
+func loadHostKey(file string) (ssh.Signer, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("host key %s: %w", file, err)
+	}
+	return signer, nil
+}
+
+// generateHostKey writes a new private key of the given type to file, in the OpenSSH format.
+// It fails if file exists.
+func generateHostKey(keyType, file string) error {
+	data, err := newHostKey(keyType)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+		return err
+	}
+	o, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := o.Write(data); err != nil {
+		o.Close()
+		return err
+	}
+	return o.Close()
+}
+
+// replaceHostKey writes a new private key of the given type over file. The key is written to a
+// temporary file first, so that file keeps the previous key if anything fails.
+func replaceHostKey(keyType, file string) error {
+	data, err := newHostKey(keyType)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(path.Dir(file), "."+path.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// newHostKey generates a private key of the given type, PEM encoded in the OpenSSH format.
+func newHostKey(keyType string) ([]byte, error) {
+	var (
+		key crypto.PrivateKey
+		err error
+	)
+	switch strings.ToLower(keyType) {
+	case HostKeyEd25519:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	case HostKeyECDSA:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case HostKeyRSA:
+		key, err = rsa.GenerateKey(rand.Reader, 4096)
+	default:
+		return nil, fmt.Errorf("unknown host key type %q", keyType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// generatePublicKey writes the public half of the host key to file in the authorized_keys
+// format, unless it already holds it.
+func (c *Server) generatePublicKey(signer ssh.Signer, file string) error {
+	data := ssh.MarshalAuthorizedKey(signer.PublicKey())
+	if existing, err := os.ReadFile(file); err == nil && string(existing) == string(data) {
+		return nil
+	} else if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.WriteFile(file, data, 0644)
+}
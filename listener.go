@@ -0,0 +1,234 @@
+package ftpserver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/oarkflow/ftp-server/proxyproto"
+)
+
+// proxyHeaderTimeout ... How long a trusted proxy has to send its PROXY header when no
+// handshake timeout is configured.
+const proxyHeaderTimeout = 10 * time.Second
+
+// Authentication methods ... The values of Listener.AuthMethods.
+const (
+	AuthPassword    = "password"
+	AuthCertificate = "certificate"
+)
+
+// Listener ... An address the server accepts connections on, with its own authentication
+// methods, IP rules, host keys, crypto policy and banner. All listeners share the user provider
+// and filesystems of the server. Fields left empty take the server-wide setting.
+type Listener struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	// Port defaults to the port of the server.
+	Port int `json:"port"`
+	// AuthMethods lists the allowed methods, AuthPassword and AuthCertificate. All methods
+	// configured on the server are allowed when empty.
+	AuthMethods []string `json:"auth_methods"`
+	// AllowedIPs and DeniedIPs are IP addresses or CIDR ranges. Connections from a denied
+	// address, or from none of the allowed ones when there are any, are closed immediately.
+	AllowedIPs []string `json:"allowed_ips"`
+	DeniedIPs  []string `json:"denied_ips"`
+	// ProxyProtocol expects connections from TrustedProxies, IP addresses or CIDR ranges of
+	// load balancers, to start with a PROXY protocol v1 or v2 header. The client address it
+	// carries replaces the address of the proxy for IP rules, logins and notifications.
+	// Connections from other addresses are taken as coming from the client directly.
+	ProxyProtocol  bool     `json:"proxy_protocol"`
+	TrustedProxies []string `json:"trusted_proxies"`
+	// HostKeyTypes are generated in the SSH directory like WithHostKeyTypes, HostKeyFiles are
+	// existing private keys used as they are.
+	HostKeyTypes []string      `json:"host_key_types"`
+	HostKeyFiles []string      `json:"host_key_files"`
+	CryptoPolicy *CryptoPolicy `json:"crypto_policy"`
+	Banner       string        `json:"banner"`
+}
+
+func (l Listener) addr() string {
+	return net.JoinHostPort(l.Address, fmt.Sprint(l.Port))
+}
+
+func (l Listener) allows(method string) bool {
+	return len(l.AuthMethods) == 0 || slices.Contains(l.AuthMethods, method)
+}
+
+// listenerConfigs returns the configured listeners with the server-wide settings filled in,
+// or a single listener on the address and port of the server when none are configured.
+func (c *Server) listenerConfigs() []Listener {
+	listeners := c.listeners
+	if len(listeners) == 0 {
+		listeners = []Listener{{Name: "default", Address: c.address, Port: c.port}}
+	}
+	out := make([]Listener, 0, len(listeners))
+	for i, l := range listeners {
+		if l.Name == "" {
+			l.Name = fmt.Sprintf("listener-%d", i)
+		}
+		if l.Port == 0 {
+			l.Port = c.port
+		}
+		if len(l.HostKeyTypes) == 0 && len(l.HostKeyFiles) == 0 {
+			l.HostKeyTypes = c.hostKeyTypes
+		}
+		if l.CryptoPolicy == nil {
+			l.CryptoPolicy = c.cryptoPolicy
+		}
+		if l.Banner == "" {
+			l.Banner = c.bannerText
+		}
+		if !l.ProxyProtocol && len(c.trustedProxies) > 0 {
+			l.ProxyProtocol, l.TrustedProxies = true, c.trustedProxies
+		}
+		out = append(out, l)
+	}
+	return out
+}
+
+// ipRules ... The parsed IP rules of a listener, along with the proxies it trusts to send a
+// PROXY header.
+type ipRules struct {
+	allowed []*net.IPNet
+	denied  []*net.IPNet
+	proxies []*net.IPNet
+}
+
+func newIPRules(l Listener) (*ipRules, error) {
+	allowed, err := parseNetworks(l.AllowedIPs)
+	if err != nil {
+		return nil, fmt.Errorf("listener %s: allowed_ips: %w", l.Name, err)
+	}
+	denied, err := parseNetworks(l.DeniedIPs)
+	if err != nil {
+		return nil, fmt.Errorf("listener %s: denied_ips: %w", l.Name, err)
+	}
+	rules := &ipRules{allowed: allowed, denied: denied}
+	if l.ProxyProtocol {
+		if len(l.TrustedProxies) == 0 {
+			return nil, fmt.Errorf("listener %s: proxy_protocol requires trusted_proxies", l.Name)
+		}
+		if rules.proxies, err = parseNetworks(l.TrustedProxies); err != nil {
+			return nil, fmt.Errorf("listener %s: trusted_proxies: %w", l.Name, err)
+		}
+	}
+	return rules, nil
+}
+
+// parseNetworks parses IP addresses and CIDR ranges, addresses being taken as single hosts.
+func parseNetworks(values []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", value)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func (r *ipRules) allows(addr net.Addr) bool {
+	if len(r.allowed) == 0 && len(r.denied) == 0 {
+		return true
+	}
+	if contains(r.denied, addr) {
+		return false
+	}
+	return len(r.allowed) == 0 || contains(r.allowed, addr)
+}
+
+// trusts tells whether connections from addr start with a PROXY header.
+func (r *ipRules) trusts(addr net.Addr) bool {
+	return len(r.proxies) > 0 && contains(r.proxies, addr)
+}
+
+func contains(networks []*net.IPNet, addr net.Addr) bool {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	return slices.ContainsFunc(networks, func(n *net.IPNet) bool { return n.Contains(ip) })
+}
+
+// serve accepts connections on the listener until it is closed. Other accept errors, such as
+// running out of file descriptors, are retried with a back-off the way net/http does.
+func (c *Server) serve(listener net.Listener, l Listener, config *ssh.ServerConfig, rules *ipRules) error {
+	var delay time.Duration
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			if delay == 0 {
+				delay = 5 * time.Millisecond
+			} else {
+				delay = min(delay*2, time.Second)
+			}
+			c.logger.Error("failed to accept connection", "listener", l.Name, "retry_in", delay.String(), "err", err)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		go c.accept(conn, l, config, rules)
+	}
+}
+
+// accept reads the PROXY header of connections from trusted proxies, then applies the IP rules
+// to the address of the client before serving the connection.
+func (c *Server) accept(conn net.Conn, l Listener, config *ssh.ServerConfig, rules *ipRules) {
+	if rules.trusts(conn.RemoteAddr()) {
+		timeout := c.timeouts.Handshake
+		if timeout <= 0 {
+			timeout = proxyHeaderTimeout
+		}
+		proxied, err := proxyproto.Accept(conn, timeout)
+		if err != nil {
+			c.logger.Warn("invalid PROXY header", "listener", l.Name, "remote_addr", conn.RemoteAddr().String(), "err", err)
+			conn.Close()
+			return
+		}
+		conn = proxied
+	}
+	if !rules.allows(conn.RemoteAddr()) {
+		c.logger.Warn("connection refused by IP rules", "listener", l.Name, "remote_addr", conn.RemoteAddr().String())
+		conn.Close()
+		return
+	}
+	c.AcceptInboundConnection(conn, config)
+}
+
+// withListener records the listener a login came through in its permissions.
+func withListener[T any](name string, callback func(ssh.ConnMetadata, T) (*ssh.Permissions, error)) func(ssh.ConnMetadata, T) (*ssh.Permissions, error) {
+	return func(conn ssh.ConnMetadata, credential T) (*ssh.Permissions, error) {
+		perms, err := callback(conn, credential)
+		if perms != nil {
+			perms.Extensions["listener"] = name
+		}
+		return perms, err
+	}
+}
@@ -0,0 +1,110 @@
+package ftpserver
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pkg/sftp"
+
+	"github.com/oarkflow/ftp-server/metrics"
+)
+
+// serverMetrics ... The metrics collected by a server with WithMetrics.
+type serverMetrics struct {
+	registry   *metrics.Registry
+	sessions   *metrics.Gauge
+	logins     *metrics.Counter
+	bytes      *metrics.Counter
+	operations *metrics.Counter
+	latency    *metrics.Histogram
+	s3Calls    *metrics.Counter
+	s3Errors   *metrics.Counter
+}
+
+func newServerMetrics() *serverMetrics {
+	r := metrics.NewRegistry()
+	return &serverMetrics{
+		registry: r,
+		sessions: r.NewGauge("sftp_active_sessions",
+			"Number of SSH connections currently open."),
+		logins: r.NewCounter("sftp_logins_total",
+			"Number of login attempts by result.", "result"),
+		bytes: r.NewCounter("sftp_transfer_bytes_total",
+			"Number of bytes transferred by filesystem type and direction.", "fs_type", "direction"),
+		operations: r.NewCounter("sftp_operations_total",
+			"Number of SFTP operations by method and result.", "method", "result"),
+		latency: r.NewHistogram("sftp_operation_duration_seconds",
+			"Time taken by SFTP operations by method.", nil, "method"),
+		s3Calls: r.NewCounter("sftp_s3_api_calls_total",
+			"Number of S3 API calls by operation.", "operation"),
+		s3Errors: r.NewCounter("sftp_s3_api_errors_total",
+			"Number of failed S3 API calls by operation.", "operation"),
+	}
+}
+
+func (m *serverMetrics) login(err error) {
+	if m == nil {
+		return
+	}
+	if err != nil {
+		m.logins.Inc("failed")
+	} else {
+		m.logins.Inc("succeeded")
+	}
+}
+
+func (m *serverMetrics) operation(method string, err error, started time.Time) {
+	if m == nil {
+		return
+	}
+	result := "ok"
+	if err != nil && !errors.Is(err, sftp.ErrSshFxOk) {
+		result = "error"
+	}
+	m.operations.Inc(method, result)
+	m.latency.Observe(time.Since(started).Seconds(), method)
+}
+
+func (m *serverMetrics) transferred(fsType, direction string, bytes int64) {
+	if m == nil {
+		return
+	}
+	m.bytes.Add(float64(bytes), fsType, direction)
+}
+
+// observeS3 is the s3.Option Observer counting API calls.
+func (m *serverMetrics) observeS3(operation string, err error) {
+	m.s3Calls.Inc(operation)
+	if err != nil {
+		m.s3Errors.Inc(operation)
+	}
+}
+
+// MetricsHandler returns the handler serving the metrics of the server in the Prometheus text
+// format, for mounting on an existing HTTP server. It is nil unless WithMetrics was used.
+func (c *Server) MetricsHandler() http.Handler {
+	if c.metrics == nil {
+		return nil
+	}
+	return c.metrics.registry.Handler()
+}
+
+// serveMetrics exposes the metrics on /metrics at the configured address.
+func (c *Server) serveMetrics() error {
+	listener, err := net.Listen("tcp", c.metricsAddress)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", c.MetricsHandler())
+	c.metricsServer = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func(srv *http.Server) {
+		if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			c.logger.Error("metrics endpoint stopped", "address", c.metricsAddress, "err", err)
+		}
+	}(c.metricsServer)
+	c.logger.Info("Serving metrics", "address", listener.Addr().String(), "path", "/metrics")
+	return nil
+}
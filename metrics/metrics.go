@@ -0,0 +1,261 @@
+// Package metrics is a small, dependency free implementation of counters, gauges and
+// histograms exposed in the Prometheus text format, for scraping from a /metrics endpoint.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets ... Upper bounds, in seconds, suited to the latency of file operations.
+var DefaultBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry ... A set of metrics written out together.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []*metric
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+type metric struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*series
+}
+
+type series struct {
+	labels []string
+	value  float64
+	// counts and sum are only used by histograms, counts holding one entry per bucket.
+	counts []uint64
+	sum    float64
+}
+
+func (r *Registry) register(name, help, kind string, buckets []float64, labels []string) *metric {
+	m := &metric{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+	if len(labels) == 0 {
+		// Metrics without labels are exposed from the start, at zero.
+		m.get(nil)
+	}
+	r.mu.Lock()
+	r.metrics = append(r.metrics, m)
+	r.mu.Unlock()
+	return m
+}
+
+// get returns the series for the label values, the caller must hold the lock unless the
+// metric is not shared yet.
+func (m *metric) get(values []string) *series {
+	if len(values) != len(m.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", m.name, len(m.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labels: append([]string(nil), values...)}
+		if m.kind == "histogram" {
+			s.counts = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+// Counter ... A value that only goes up, such as a number of requests.
+type Counter struct{ m *metric }
+
+// NewCounter registers a counter partitioned by the given labels.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{m: r.register(name, help, "counter", nil, labels)}
+}
+
+// Add adds v, which must not be negative, to the series with the label values.
+func (c *Counter) Add(v float64, values ...string) {
+	if v < 0 {
+		return
+	}
+	c.m.mu.Lock()
+	c.m.get(values).value += v
+	c.m.mu.Unlock()
+}
+
+// Inc adds one to the series with the label values.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Gauge ... A value that goes up and down, such as a number of open sessions.
+type Gauge struct{ m *metric }
+
+// NewGauge registers a gauge partitioned by the given labels.
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{m: r.register(name, help, "gauge", nil, labels)}
+}
+
+// Set sets the series with the label values to v.
+func (g *Gauge) Set(v float64, values ...string) {
+	g.m.mu.Lock()
+	g.m.get(values).value = v
+	g.m.mu.Unlock()
+}
+
+// Add adds v to the series with the label values.
+func (g *Gauge) Add(v float64, values ...string) {
+	g.m.mu.Lock()
+	g.m.get(values).value += v
+	g.m.mu.Unlock()
+}
+
+// Inc adds one to the series with the label values.
+func (g *Gauge) Inc(values ...string) {
+	g.Add(1, values...)
+}
+
+// Dec subtracts one from the series with the label values.
+func (g *Gauge) Dec(values ...string) {
+	g.Add(-1, values...)
+}
+
+// Histogram ... Counts observations, such as latencies, in buckets.
+type Histogram struct{ m *metric }
+
+// NewHistogram registers a histogram with the given bucket upper bounds, DefaultBuckets when
+// nil, partitioned by the given labels.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Histogram{m: r.register(name, help, "histogram", buckets, labels)}
+}
+
+// Observe records v in the series with the label values.
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.m.mu.Lock()
+	defer h.m.mu.Unlock()
+	s := h.m.get(values)
+	s.value++
+	s.sum += v
+	if i := sort.SearchFloat64s(h.m.buckets, v); i < len(s.counts) {
+		s.counts[i]++
+	}
+}
+
+// WriteTo writes every metric in the Prometheus text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := append([]*metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// Handler serves the metrics of the registry.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(w)
+	})
+}
+
+func (m *metric) write(w *bufio.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, escape(m.help, false), m.name, m.kind)
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := m.series[key]
+		if m.kind != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, m.labelPairs(s.labels, ""), formatFloat(s.value))
+			continue
+		}
+		var cumulative uint64
+		for i, upper := range m.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, m.labelPairs(s.labels, formatFloat(upper)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %s\n", m.name, m.labelPairs(s.labels, "+Inf"), formatFloat(s.value))
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, m.labelPairs(s.labels, ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %s\n", m.name, m.labelPairs(s.labels, ""), formatFloat(s.value))
+	}
+}
+
+// labelPairs formats the label set of a series, adding the le label of a histogram bucket
+// when le is set.
+func (m *metric) labelPairs(values []string, le string) string {
+	if len(values) == 0 && le == "" {
+		return ""
+	}
+	pairs := make([]string, 0, len(values)+1)
+	for i, name := range m.labels {
+		pairs = append(pairs, name+`="`+escape(values[i], true)+`"`)
+	}
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escape(s string, quotes bool) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	if quotes {
+		s = strings.ReplaceAll(s, `"`, `\"`)
+	}
+	return s
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -8,6 +8,8 @@ type Filesystem struct {
 	Fs          string         `json:"fs"`
 	Permissions []string       `json:"permissions"`
 	Params      map[string]any `json:"params"`
+	// ReadOnly refuses every write and change, whatever the permissions.
+	ReadOnly bool `json:"read_only"`
 }
 
 type User struct {
@@ -18,6 +20,14 @@ type User struct {
 	DefaultFilesystem string        `json:"default_filesystem"`
 	Filesystem        *Filesystem   `json:"filesystem"`
 	Permissions       []string      `json:"permissions"`
+	// UploadRate and DownloadRate limit the bandwidth of the user, shared by all of their
+	// sessions, in bytes per second. Zero means unlimited.
+	UploadRate   int64 `json:"upload_rate"`
+	DownloadRate int64 `json:"download_rate"`
+	// Disabled users cannot log in.
+	Disabled bool `json:"disabled"`
+	// ReadOnly users can only list and download files, on every filesystem.
+	ReadOnly bool `json:"read_only"`
 }
 
 func (u User) GetFilesystem() (*Filesystem, error) {
@@ -1,8 +1,13 @@
 package ftpserver
 
 import (
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/oarkflow/ftp-server/audit"
 	"github.com/oarkflow/ftp-server/fs"
 	interfaces2 "github.com/oarkflow/ftp-server/providers"
+	"github.com/oarkflow/ftp-server/xferlog"
 )
 
 func WithUserProvider(provider interfaces2.UserProvider) func(*Server) {
@@ -53,8 +58,154 @@ func WithCredentialValidator(val func(server *Server, r fs.AuthenticationRequest
 	}
 }
 
+// WithNotificationCallback calls callback for every notification, in addition to any other
+// notification callback or webhook.
 func WithNotificationCallback(callback NotificationHandler) func(srv *Server) {
 	return func(o *Server) {
-		o.notificationCallback = callback
+		o.notificationCallback = JoinNotificationHandlers(o.notificationCallback, callback)
+	}
+}
+
+// WithNotificationDispatcher delivers notifications asynchronously from a pool of workers,
+// retrying failed deliveries and optionally spooling them to disk until they succeed.
+func WithNotificationDispatcher(config DispatcherConfig) func(srv *Server) {
+	return func(o *Server) {
+		o.dispatcherConfig = &config
+	}
+}
+
+// WithWebhook posts notifications as JSON to the configured URLs, in addition to any other
+// notification callback. Combine it with WithNotificationDispatcher so that slow or failing
+// endpoints are retried in the background.
+func WithWebhook(config WebhookConfig) func(srv *Server) {
+	return func(o *Server) {
+		o.notificationCallback = JoinNotificationHandlers(o.notificationCallback, NewWebhookSink(config))
+	}
+}
+
+// WithActions adds post-processing actions that run after a matching upload completes.
+func WithActions(actions ...Action) func(srv *Server) {
+	return func(o *Server) {
+		o.actions = append(o.actions, actions...)
+	}
+}
+
+// WithAuditLog records every operation, including listings and failed logins, in the audit
+// log. The server takes ownership of the log and closes it in Close.
+func WithAuditLog(log *audit.Log) func(srv *Server) {
+	return func(o *Server) {
+		o.auditLog = log
+	}
+}
+
+// WithTransferLog writes a line for every completed upload and download to the transfer log,
+// in the xferlog or W3C format it was created with. The server takes ownership of the log and
+// closes it in Close.
+func WithTransferLog(log *xferlog.Writer) func(srv *Server) {
+	return func(o *Server) {
+		o.transferLog = log
+	}
+}
+
+// WithMetrics collects Prometheus metrics about sessions, logins, transfers, SFTP operations
+// and S3 API calls, and serves them on /metrics at address. With an empty address the metrics
+// are only available through MetricsHandler.
+func WithMetrics(address string) func(srv *Server) {
+	return func(o *Server) {
+		o.metrics = newServerMetrics()
+		o.metricsAddress = address
+	}
+}
+
+// WithTracerProvider traces every SSH session and SFTP request, along with the S3 API calls
+// they make, with tracers from tp. See the tracing package to create one exporting over OTLP
+// or to stdout. The server shuts the provider down in Close when it supports it.
+func WithTracerProvider(tp trace.TracerProvider) func(srv *Server) {
+	return func(o *Server) {
+		o.tracerProvider = tp
+		o.tracer = tp.Tracer(tracerName)
+	}
+}
+
+// WithBandwidthLimit limits the combined bandwidth of all uploads and of all downloads, in
+// bytes per second. Zero leaves a direction unlimited. Limits of individual users are set with
+// the UploadRate and DownloadRate fields of models.User and apply on top of these.
+func WithBandwidthLimit(upload, download int64) func(srv *Server) {
+	return func(o *Server) {
+		o.globalLimit = newBandwidth(upload, download)
+	}
+}
+
+// WithTimeouts bounds the handshake, idle time and duration of sessions, and enables keepalives
+// that disconnect unresponsive clients.
+func WithTimeouts(timeouts Timeouts) func(srv *Server) {
+	return func(o *Server) {
+		o.timeouts = timeouts
+	}
+}
+
+// WithHostKeyTypes sets the types of host keys the server offers, generating the missing ones
+// in the SSH directory. Supported types are HostKeyEd25519, HostKeyECDSA and HostKeyRSA.
+func WithHostKeyTypes(types ...string) func(srv *Server) {
+	return func(o *Server) {
+		o.hostKeyTypes = types
+	}
+}
+
+// WithUserCAs accepts public key logins with OpenSSH user certificates signed by one of the
+// given certificate authorities, see LoadUserCAs. The user provider has to implement
+// providers.UserLookup.
+func WithUserCAs(keys ...ssh.PublicKey) func(srv *Server) {
+	return func(o *Server) {
+		o.userCAs = append(o.userCAs, keys...)
+	}
+}
+
+// WithCryptoPolicy restricts the ciphers, key exchanges, MACs and host key algorithms the
+// server negotiates, e.g. CryptoPolicy{Preset: CryptoPolicyModern}. The policy is validated
+// when the server starts.
+func WithCryptoPolicy(policy CryptoPolicy) func(srv *Server) {
+	return func(o *Server) {
+		o.cryptoPolicy = &policy
+	}
+}
+
+// WithBanner shows text to clients before they authenticate, e.g. an authorized-use notice.
+func WithBanner(text string) func(srv *Server) {
+	return func(o *Server) {
+		o.bannerText = text
+	}
+}
+
+// WithLoginMessage shows a message to users once they have logged in. It is a text/template
+// executed with a LoginMessage.
+func WithLoginMessage(tmpl string) func(srv *Server) {
+	return func(o *Server) {
+		o.loginMessage = tmpl
+	}
+}
+
+// WithListeners replaces the single listener on the address and port of the server with the
+// given listeners, each with its own authentication methods, IP rules, host keys, crypto
+// policy and banner.
+func WithListeners(listeners ...Listener) func(srv *Server) {
+	return func(o *Server) {
+		o.listeners = append(o.listeners, listeners...)
+	}
+}
+
+// WithProxyProtocol reads the PROXY protocol header, v1 or v2, that load balancers at the given
+// IP addresses or CIDR ranges send, so that the real client address is used for IP rules,
+// logins and notifications. It applies to listeners that do not configure ProxyProtocol.
+func WithProxyProtocol(trustedProxies ...string) func(srv *Server) {
+	return func(o *Server) {
+		o.trustedProxies = append(o.trustedProxies, trustedProxies...)
+	}
+}
+
+// WithReadOnly starts the server in read-only mode, see Server.SetReadOnly.
+func WithReadOnly(val bool) func(srv *Server) {
+	return func(o *Server) {
+		o.readOnly.Store(val)
 	}
 }
@@ -2,7 +2,11 @@ package providers
 
 import (
 	"crypto/rand"
+	"encoding/json"
 	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
 	"sync"
 
 	"github.com/oarkflow/hash"
@@ -20,9 +24,27 @@ type JsonFileProvider struct {
 }
 
 func (p *JsonFileProvider) Login(username, pass string) (*fs.AuthenticationResponse, error) {
+	p.mu.RLock()
 	user, exists := p.users[username]
+	p.mu.RUnlock()
 	matched, err := hash.Match(pass, user.Password, p.hashAlgo)
-	if !exists || err != nil || !matched {
+	if !exists || user.Disabled || err != nil || !matched {
+		return nil, errs.InvalidCredentialsError{}
+	}
+	n, _ := rand.Int(rand.Reader, big.NewInt(9223372036854775807))
+	return &fs.AuthenticationResponse{
+		Server: "none",
+		Token:  n.String(),
+		User:   user,
+	}, nil
+}
+
+// Lookup returns the user without checking their password.
+func (p *JsonFileProvider) Lookup(username string) (*fs.AuthenticationResponse, error) {
+	p.mu.RLock()
+	user, exists := p.users[username]
+	p.mu.RUnlock()
+	if !exists || user.Disabled {
 		return nil, errs.InvalidCredentialsError{}
 	}
 	n, _ := rand.Int(rand.Reader, big.NewInt(9223372036854775807))
@@ -39,6 +61,58 @@ func (p *JsonFileProvider) Register(user models.User) {
 	p.users[user.Username] = user
 }
 
+// User returns the registered user with the given name.
+func (p *JsonFileProvider) User(username string) (models.User, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	user, exists := p.users[username]
+	return user, exists
+}
+
+// Users returns the registered users, sorted by username.
+func (p *JsonFileProvider) Users() []models.User {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	users := make([]models.User, 0, len(p.users))
+	for _, user := range p.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
+	return users
+}
+
+// HashPassword hashes a password with the algorithm of the provider, for storing in
+// models.User.Password.
+func (p *JsonFileProvider) HashPassword(pass string) (string, error) {
+	return hash.Make(pass, p.hashAlgo)
+}
+
+// Save writes the registered users to file as a JSON object keyed by username, the format read
+// back by the config package. The file is replaced atomically.
+func (p *JsonFileProvider) Save(file string) error {
+	p.mu.RLock()
+	data, err := json.MarshalIndent(p.users, "", "\t")
+	p.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
 func NewJsonFileProvider(hashAlgo, alternateHashAlgo string, users ...map[string]models.User) *JsonFileProvider {
 	user := make(map[string]models.User)
 	if len(users) > 0 && users[0] != nil {
@@ -13,3 +13,9 @@ type UserProvider interface {
 	Login(user, pass string) (*fs.AuthenticationResponse, error)
 	Register(user models.User)
 }
+
+// UserLookup ... Implemented by providers able to find a user without checking a password, for
+// logins already authenticated by other means such as an SSH certificate.
+type UserLookup interface {
+	Lookup(user string) (*fs.AuthenticationResponse, error)
+}
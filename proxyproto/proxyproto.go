@@ -0,0 +1,178 @@
+// Package proxyproto reads the PROXY protocol header, version 1 or 2, that load balancers put
+// at the start of a connection to pass on the address of the client.
+//
+// See https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNoHeader ... The connection does not start with a PROXY header.
+	ErrNoHeader = errors.New("proxyproto: no PROXY header")
+	// ErrInvalidHeader ... The connection starts with a malformed PROXY header.
+	ErrInvalidHeader = errors.New("proxyproto: invalid PROXY header")
+)
+
+var signatureV2 = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// maxHeaderV1 ... The longest a version 1 header can be, CRLF included.
+const maxHeaderV1 = 107
+
+// Conn ... A connection whose addresses are the ones announced by its PROXY header.
+type Conn struct {
+	net.Conn
+	reader *bufio.Reader
+	remote net.Addr
+	local  net.Addr
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// RemoteAddr returns the address of the client, as announced by the proxy.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// LocalAddr returns the address the client connected to, as announced by the proxy.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.local
+}
+
+// Accept reads the PROXY header at the start of conn, giving up after timeout when it is not
+// zero. Headers of health checks made by the proxy itself, LOCAL or UNKNOWN, keep the
+// addresses of the connection.
+func Accept(conn net.Conn, timeout time.Duration) (*Conn, error) {
+	if timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		defer conn.SetReadDeadline(time.Time{})
+	}
+	c := &Conn{
+		Conn:   conn,
+		reader: bufio.NewReaderSize(conn, 512),
+		remote: conn.RemoteAddr(),
+		local:  conn.LocalAddr(),
+	}
+	first, err := c.reader.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch first[0] {
+	case 'P':
+		err = c.readV1()
+	case signatureV2[0]:
+		err = c.readV2()
+	default:
+		err = ErrNoHeader
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// readV1 reads a header such as "PROXY TCP4 192.0.2.1 198.51.100.1 56324 22\r\n".
+func (c *Conn) readV1() error {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= maxHeaderV1 {
+			return ErrInvalidHeader
+		}
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		line = append(line, b)
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return ErrInvalidHeader
+	}
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil
+	case "TCP4", "TCP6":
+	default:
+		return fmt.Errorf("%w: unsupported protocol %q", ErrInvalidHeader, fields[1])
+	}
+	if len(fields) != 6 {
+		return ErrInvalidHeader
+	}
+	src, err := tcpAddr(fields[2], fields[4])
+	if err != nil {
+		return err
+	}
+	dst, err := tcpAddr(fields[3], fields[5])
+	if err != nil {
+		return err
+	}
+	c.remote, c.local = src, dst
+	return nil
+}
+
+func tcpAddr(host, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	p, err := strconv.ParseUint(port, 10, 16)
+	if ip == nil || err != nil {
+		return nil, ErrInvalidHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+// readV2 reads a binary header.
+func (c *Conn) readV2() error {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return err
+	}
+	if !bytes.Equal(header[:12], signatureV2) || header[12]>>4 != 2 {
+		return ErrInvalidHeader
+	}
+	command, family := header[12]&0x0f, header[13]
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return err
+	}
+	switch command {
+	case 0x0:
+		// LOCAL, sent by the proxy for its own health checks.
+		return nil
+	case 0x1:
+	default:
+		return ErrInvalidHeader
+	}
+	var size int
+	switch family {
+	case 0x11:
+		size = net.IPv4len
+	case 0x21:
+		size = net.IPv6len
+	default:
+		// Only TCP over IPv4 and IPv6 carry addresses that are meaningful here.
+		return nil
+	}
+	if len(payload) < 2*size+4 {
+		return ErrInvalidHeader
+	}
+	c.remote = &net.TCPAddr{
+		IP:   net.IP(payload[:size]),
+		Port: int(binary.BigEndian.Uint16(payload[2*size:])),
+	}
+	c.local = &net.TCPAddr{
+		IP:   net.IP(payload[size : 2*size]),
+		Port: int(binary.BigEndian.Uint16(payload[2*size+2:])),
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package proxyproto_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/oarkflow/ftp-server/proxyproto"
+)
+
+// greeting stands for the SSH traffic following the header, which must reach the server intact.
+const greeting = "SSH-2.0-client\r\n"
+
+var signatureV2 = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// v2 builds a version 2 header announcing length bytes of payload, of which only payload is
+// sent.
+func v2(command, family byte, length int, payload ...byte) string {
+	header := append([]byte(nil), signatureV2...)
+	header = append(header, 0x20|command, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(length))
+	return string(append(header, payload...))
+}
+
+func addresses(src, dst net.IP, srcPort, dstPort uint16) []byte {
+	payload := append(append([]byte(nil), src...), dst...)
+	payload = binary.BigEndian.AppendUint16(payload, srcPort)
+	return binary.BigEndian.AppendUint16(payload, dstPort)
+}
+
+func TestAccept(t *testing.T) {
+	ipv4 := addresses(net.ParseIP("192.0.2.1").To4(), net.ParseIP("198.51.100.1").To4(), 56324, 22)
+	ipv6 := addresses(net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), 56324, 22)
+
+	tests := []struct {
+		name   string
+		header string
+		// remote and local are the addresses announced, empty when the connection keeps its
+		// own.
+		remote, local string
+		err           error
+	}{
+		{name: "v1 tcp4", header: "PROXY TCP4 192.0.2.1 198.51.100.1 56324 22\r\n", remote: "192.0.2.1:56324", local: "198.51.100.1:22"},
+		{name: "v1 tcp6", header: "PROXY TCP6 2001:db8::1 2001:db8::2 56324 22\r\n", remote: "[2001:db8::1]:56324", local: "[2001:db8::2]:22"},
+		{name: "v1 unknown", header: "PROXY UNKNOWN\r\n"},
+		{name: "v1 unknown with addresses", header: "PROXY UNKNOWN 192.0.2.1 198.51.100.1 56324 22\r\n"},
+		{name: "v1 truncated", header: "PROXY TCP4 192.0.2.1", err: io.EOF},
+		{name: "v1 too long", header: "PROXY TCP6 " + strings.Repeat("f", 120) + "\r\n", err: proxyproto.ErrInvalidHeader},
+		{name: "v1 missing ports", header: "PROXY TCP4 192.0.2.1 198.51.100.1\r\n", err: proxyproto.ErrInvalidHeader},
+		{name: "v1 invalid address", header: "PROXY TCP4 192.0.2 198.51.100.1 56324 22\r\n", err: proxyproto.ErrInvalidHeader},
+		{name: "v1 invalid port", header: "PROXY TCP4 192.0.2.1 198.51.100.1 65536 22\r\n", err: proxyproto.ErrInvalidHeader},
+		{name: "v1 unsupported protocol", header: "PROXY UDP4 192.0.2.1 198.51.100.1 56324 22\r\n", err: proxyproto.ErrInvalidHeader},
+		{name: "v1 bad signature", header: "PROXZ TCP4 192.0.2.1 198.51.100.1 56324 22\r\n", err: proxyproto.ErrInvalidHeader},
+		{name: "v2 tcp4", header: v2(0x1, 0x11, len(ipv4), ipv4...), remote: "192.0.2.1:56324", local: "198.51.100.1:22"},
+		{name: "v2 tcp6", header: v2(0x1, 0x21, len(ipv6), ipv6...), remote: "[2001:db8::1]:56324", local: "[2001:db8::2]:22"},
+		{name: "v2 tcp4 with tlvs", header: v2(0x1, 0x11, len(ipv4)+3, append(ipv4, 0x04, 0x00, 0x00)...), remote: "192.0.2.1:56324", local: "198.51.100.1:22"},
+		{name: "v2 local", header: v2(0x0, 0x11, len(ipv4), ipv4...)},
+		{name: "v2 unspecified family", header: v2(0x1, 0x00, 0)},
+		{name: "v2 udp", header: v2(0x1, 0x12, len(ipv4), ipv4...)},
+		{name: "v2 truncated header", header: string(signatureV2[:8]), err: io.ErrUnexpectedEOF},
+		{name: "v2 truncated payload", header: v2(0x1, 0x11, len(ipv4), ipv4[:5]...), err: io.ErrUnexpectedEOF},
+		{name: "v2 payload shorter than tcp4 addresses", header: v2(0x1, 0x11, 8, ipv4[:8]...), err: proxyproto.ErrInvalidHeader},
+		{name: "v2 payload shorter than tcp6 addresses", header: v2(0x1, 0x21, len(ipv4), ipv4...), err: proxyproto.ErrInvalidHeader},
+		{name: "v2 bad signature", header: "\r\n\r\n\x00\r\nQUIZ\n\x21\x11\x00\x0c" + string(ipv4), err: proxyproto.ErrInvalidHeader},
+		{name: "v2 bad version", header: string(signatureV2) + "\x11\x11\x00\x0c" + string(ipv4), err: proxyproto.ErrInvalidHeader},
+		{name: "v2 bad command", header: v2(0x2, 0x11, len(ipv4), ipv4...), err: proxyproto.ErrInvalidHeader},
+		{name: "no header", header: "", err: proxyproto.ErrNoHeader},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer server.Close()
+			go func() {
+				defer client.Close()
+				// Truncated headers are followed by the connection closing.
+				if tt.err == io.EOF || tt.err == io.ErrUnexpectedEOF {
+					client.Write([]byte(tt.header))
+					return
+				}
+				client.Write([]byte(tt.header + greeting))
+			}()
+
+			conn, err := proxyproto.Accept(server, 0)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("got %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			remote, local := server.RemoteAddr().String(), server.LocalAddr().String()
+			if tt.remote != "" {
+				remote, local = tt.remote, tt.local
+			}
+			if got := conn.RemoteAddr().String(); got != remote {
+				t.Errorf("remote address is %s, want %s", got, remote)
+			}
+			if got := conn.LocalAddr().String(); got != local {
+				t.Errorf("local address is %s, want %s", got, local)
+			}
+			data, err := io.ReadAll(conn)
+			if err != nil || string(data) != greeting {
+				t.Fatalf("read %q after the header, %v, want %q", data, err, greeting)
+			}
+		})
+	}
+}
@@ -1,38 +1,75 @@
 package ftpserver
 
 import (
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/x509"
+	"context"
+	"encoding/hex"
 	"encoding/json"
-	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
 	"net"
-	"os"
+	"net/http"
 	"path"
 	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
 	
 	"github.com/pkg/sftp"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/crypto/ssh"
 	
+	"github.com/oarkflow/ftp-server/audit"
 	"github.com/oarkflow/ftp-server/log"
 	"github.com/oarkflow/ftp-server/providers"
 	
 	"github.com/oarkflow/ftp-server/fs"
+	"github.com/oarkflow/ftp-server/fs/afos"
+	"github.com/oarkflow/ftp-server/fs/mem"
 	"github.com/oarkflow/ftp-server/log/oarklog"
 	"github.com/oarkflow/ftp-server/models"
 	"github.com/oarkflow/ftp-server/utils"
+	"github.com/oarkflow/ftp-server/xferlog"
 )
 
+// DefaultPort ... The port the server listens on unless WithPort says otherwise.
+const DefaultPort = 2022
+
 type NotificationHandler func(notification Notification) error
 type Server struct {
 	userProvider         providers.UserProvider
 	logger               log.Logger
 	credentialValidator  func(server *Server, r fs.AuthenticationRequest) (*fs.AuthenticationResponse, error)
 	notificationCallback NotificationHandler
+	dispatcherConfig     *DispatcherConfig
+	dispatcher           *Dispatcher
+	actions              []Action
+	auditLog             *audit.Log
+	transferLog          *xferlog.Writer
+	metrics              *serverMetrics
+	metricsAddress       string
+	metricsServer        *http.Server
+	tracerProvider       trace.TracerProvider
+	tracer               trace.Tracer
+	globalLimit          *bandwidth
+	bandwidthMu          sync.Mutex
+	userLimits           map[string]*bandwidth
+	timeouts             Timeouts
+	hostKeyTypes         []string
+	listeners            []Listener
+	netListeners         []net.Listener
+	mu                   sync.Mutex
+	userCAs              []ssh.PublicKey
+	cryptoPolicy         *CryptoPolicy
+	bannerText           string
+	loginMessage         string
+	loginTemplate        *template.Template
+	trustedProxies       []string
+	readOnly             atomic.Bool
+	locks                *afos.LockManager
+	memStores            *mem.Stores
 	basePath             string
 	sshPath              string
 	privateKey           string
@@ -48,13 +85,17 @@ func defaultServer() *Server {
 	return &Server{
 		basePath:     basePath,
 		sshPath:      ".ssh",
-		port:         2022,
+		port:         DefaultPort,
 		privateKey:   "id_rsa",
 		publicKey:    "id_rsa.pub",
 		address:      "0.0.0.0",
 		logger:       oarklog.Default(),
 		notify:       true,
 		userProvider: userProvider,
+		locks:        afos.NewLockManager(),
+		memStores:    mem.NewStores(),
+		tracer:       noopTracer,
+		hostKeyTypes: DefaultHostKeyTypes,
 		credentialValidator: func(server *Server, r fs.AuthenticationRequest) (*fs.AuthenticationResponse, error) {
 			return server.userProvider.Login(r.User, r.Pass)
 		},
@@ -76,31 +117,141 @@ func newServer(svr *Server, opts ...func(*Server)) *Server {
 	for _, o := range opts {
 		o(svr)
 	}
+	if svr.dispatcherConfig != nil && svr.notificationCallback != nil {
+		svr.dispatcher = NewDispatcher(svr.notificationCallback, *svr.dispatcherConfig, svr.logger)
+	}
 	return svr
 }
 
+// publish hands the notification to the notification callback, through the dispatcher when
+// asynchronous delivery is configured.
+func (c *Server) publish(notification Notification) error {
+	if !c.notify || c.notificationCallback == nil {
+		return nil
+	}
+	if c.dispatcher != nil {
+		return c.dispatcher.Dispatch(notification)
+	}
+	err := c.notificationCallback(notification)
+	if err != nil {
+		c.logger.Error("notification callback failed", "event", notification.Event, "err", err)
+	}
+	return err
+}
+
+// audit appends the record to the audit log, if one is configured.
+func (c *Server) audit(record audit.Record) {
+	if c.auditLog == nil {
+		return
+	}
+	if host, _, err := net.SplitHostPort(record.IP); err == nil {
+		record.IP = host
+	}
+	if err := c.auditLog.Write(record); err != nil {
+		c.logger.Error("could not write audit record", "operation", record.Operation, "err", err)
+	}
+}
+
+// transferred appends the completed transfer to the transfer log, if one is configured.
+func (c *Server) transferred(entry xferlog.Entry) {
+	if c.transferLog == nil {
+		return
+	}
+	if host, _, err := net.SplitHostPort(entry.RemoteHost); err == nil {
+		entry.RemoteHost = host
+	}
+	if err := c.transferLog.Write(entry); err != nil {
+		c.logger.Error("could not write transfer log", "filename", entry.Filename, "err", err)
+	}
+}
+
+// Close stops the listeners and releases the resources held by the server, delivering or
+// spooling any pending notifications, closing the audit and transfer logs and flushing
+// pending spans.
+func (c *Server) Close() error {
+	var errs []error
+	if c.dispatcher != nil {
+		errs = append(errs, c.dispatcher.Close())
+	}
+	if c.auditLog != nil {
+		errs = append(errs, c.auditLog.Close())
+	}
+	if c.transferLog != nil {
+		errs = append(errs, c.transferLog.Close())
+	}
+	if c.metricsServer != nil {
+		errs = append(errs, c.metricsServer.Close())
+	}
+	c.mu.Lock()
+	for _, listener := range c.netListeners {
+		if err := listener.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
+	c.netListeners = nil
+	c.mu.Unlock()
+	if tp, ok := c.tracerProvider.(interface{ Shutdown(context.Context) error }); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		errs = append(errs, tp.Shutdown(ctx))
+		cancel()
+	}
+	return errors.Join(errs...)
+}
+
 func (c *Server) AddUser(user models.User) {
 	c.userProvider.Register(user)
 }
 
+// SetReadOnly switches the server in or out of read-only mode, e.g. for maintenance. While it
+// is read-only every write and change is refused with a permission denied error, in existing
+// sessions as well as new ones.
+func (c *Server) SetReadOnly(readOnly bool) {
+	if c.readOnly.Swap(readOnly) != readOnly {
+		c.logger.Info("Read-only mode changed", "read_only", readOnly)
+	}
+}
+
+// ReadOnly reports whether the server is in read-only mode. Filesystems created with NewFS
+// have no server, which is never read-only.
+func (c *Server) ReadOnly() bool {
+	return c != nil && c.readOnly.Load()
+}
+
 func (c *Server) Validate(conn ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
-	now := time.Now().UTC()
-	nowString := now.Format(time.RFC3339)
-	user := conn.User()
-	clientVersion := string(conn.ClientVersion())
-	remoteAddr := conn.RemoteAddr().String()
-	sessionID := conn.SessionID()
 	resp, err := c.credentialValidator(c, fs.AuthenticationRequest{
-		User:          user,
+		User:          conn.User(),
 		Pass:          string(pass),
-		IP:            remoteAddr,
-		SessionID:     sessionID,
+		IP:            conn.RemoteAddr().String(),
+		SessionID:     conn.SessionID(),
 		ClientVersion: conn.ClientVersion(),
 	})
-	
 	if err != nil {
-		return nil, err
+		return nil, c.loginFailed(conn, err)
 	}
+	return c.authorize(conn, "password", resp)
+}
+
+// loginFailed records a failed login and returns its error.
+func (c *Server) loginFailed(conn ssh.ConnMetadata, err error) error {
+	c.metrics.login(err)
+	record := audit.Record{
+		Time:      time.Now().UTC(),
+		SessionID: hex.EncodeToString(conn.SessionID()),
+		User:      conn.User(),
+		IP:        conn.RemoteAddr().String(),
+		Operation: "Login",
+		Error:     err.Error(),
+	}
+	record.Code, record.Result = audit.Status(err)
+	c.audit(record)
+	return err
+}
+
+// authorize checks the login of a user, whatever the method used, and returns the permissions
+// carrying the session details to the SFTP handlers. It records nothing: public key callbacks
+// also run for keys the client has not yet proven to hold, the login is only recorded by
+// loggedIn once the handshake has completed.
+func (c *Server) authorize(conn ssh.ConnMetadata, method string, resp *fs.AuthenticationResponse) (*ssh.Permissions, error) {
 	fst, err := resp.User.GetFilesystem()
 	if err != nil {
 		return nil, err
@@ -116,69 +267,161 @@ func (c *Server) Validate(conn ssh.ConnMetadata, pass []byte) (*ssh.Permissions,
 	} else {
 		useDefaultFS = "true"
 	}
-	c.logger.Info("User Authenticated",
-		"user", user,
-		"login_at", nowString,
-		"event", "Login",
-		"remote_addr", remoteAddr,
-		"client_version", clientVersion,
-		"fs_type", fst.Fs,
-	)
-	if c.notify && c.notificationCallback != nil {
-		c.notificationCallback(Notification{
-			User:          user,
-			ClientVersion: clientVersion,
-			RemoteAddr:    remoteAddr,
-			Time:          now,
-			Event:         "Login",
-			FsType:        fst.Fs,
-		})
+	fsType := "os"
+	if fst != nil {
+		fsType = fst.Fs
 	}
 	sshPerm := &ssh.Permissions{
 		Extensions: map[string]string{
 			"uuid":           resp.Server,
-			"user":           user,
-			"remote_addr":    remoteAddr,
+			"user":           conn.User(),
+			"remote_addr":    conn.RemoteAddr().String(),
 			"filesystem":     filesystem,
 			"default_fs":     useDefaultFS,
-			"client_version": clientVersion,
-			"login_at":       nowString,
+			"fs_type":        fsType,
+			"client_version": string(conn.ClientVersion()),
+			"login_at":       time.Now().UTC().Format(time.RFC3339),
+			"session_id":     hex.EncodeToString(conn.SessionID()),
+			"auth_method":    method,
 		},
 	}
+	if resp.User.UploadRate > 0 {
+		sshPerm.Extensions["upload_rate"] = strconv.FormatInt(resp.User.UploadRate, 10)
+	}
+	if resp.User.DownloadRate > 0 {
+		sshPerm.Extensions["download_rate"] = strconv.FormatInt(resp.User.DownloadRate, 10)
+	}
+	if resp.User.ReadOnly {
+		sshPerm.Extensions["read_only"] = "true"
+	}
 	return sshPerm, nil
 }
 
+// loggedIn logs, audits, counts and notifies the login of an authenticated session.
+func (c *Server) loggedIn(sconn *ssh.ServerConn) {
+	if sconn.Permissions == nil || sconn.Permissions.Extensions["auth_method"] == "" {
+		return
+	}
+	ext := sconn.Permissions.Extensions
+	now := time.Now().UTC()
+	if loginAt, err := time.Parse(time.RFC3339, ext["login_at"]); err == nil {
+		now = loginAt
+	}
+	c.metrics.login(nil)
+	c.logger.Info("User Authenticated",
+		"user", ext["user"],
+		"login_at", ext["login_at"],
+		"event", "Login",
+		"remote_addr", ext["remote_addr"],
+		"client_version", ext["client_version"],
+		"fs_type", ext["fs_type"],
+		"auth_method", ext["auth_method"],
+	)
+	record := audit.Record{
+		Time:      now,
+		SessionID: ext["session_id"],
+		User:      ext["user"],
+		IP:        ext["remote_addr"],
+		Operation: "Login",
+		FsType:    ext["fs_type"],
+	}
+	record.Code, record.Result = audit.Status(nil)
+	c.audit(record)
+	c.publish(Notification{
+		User:          ext["user"],
+		ClientVersion: ext["client_version"],
+		RemoteAddr:    ext["remote_addr"],
+		Time:          now,
+		Event:         "Login",
+		FsType:        ext["fs_type"],
+	})
+}
+
 // Initialize the SFTP server and add a persistent listener to handle inbound SFTP connections.
 func (c *Server) Initialize() error {
-	config, err := c.setupSSH()
-	if err != nil {
-		return err
+	if c.dispatcher != nil {
+		if err := c.dispatcher.Start(); err != nil {
+			return err
+		}
 	}
-	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", c.address, c.port))
-	if err != nil {
+	if c.metrics != nil && c.metricsAddress != "" {
+		if err := c.serveMetrics(); err != nil {
+			return err
+		}
+	}
+	if err := c.parseLoginMessage(); err != nil {
 		return err
 	}
 	
-	c.logger.Info("Listening connections", "host", c.address, "port", c.port)
-	
-	for {
-		conn, _ := listener.Accept()
-		if conn != nil {
-			go c.AcceptInboundConnection(conn, config)
+	// Set up every listener before serving any so that configuration errors surface at once.
+	type endpoint struct {
+		Listener
+		config   *ssh.ServerConfig
+		rules    *ipRules
+		listener net.Listener
+	}
+	var endpoints []endpoint
+	for _, l := range c.listenerConfigs() {
+		config, err := c.setupSSH(l)
+		if err != nil {
+			return fmt.Errorf("listener %s: %w", l.Name, err)
 		}
+		rules, err := newIPRules(l)
+		if err != nil {
+			return err
+		}
+		endpoints = append(endpoints, endpoint{Listener: l, config: config, rules: rules})
 	}
+	for i := range endpoints {
+		listener, err := net.Listen("tcp", endpoints[i].addr())
+		if err != nil {
+			for _, e := range endpoints[:i] {
+				e.listener.Close()
+			}
+			return err
+		}
+		endpoints[i].listener = listener
+	}
+	
+	c.mu.Lock()
+	errs := make(chan error, len(endpoints))
+	for _, e := range endpoints {
+		c.netListeners = append(c.netListeners, e.listener)
+		c.logger.Info("Listening connections", "listener", e.Name, "host", e.Address, "port", e.Port)
+		go func(e endpoint) {
+			errs <- c.serve(e.listener, e.Listener, e.config, e.rules)
+		}(e)
+	}
+	c.mu.Unlock()
+	return <-errs
 }
 
 // AcceptInboundConnection ... Handles an inbound connection to the instance and determines if
 // we should serve the request or not.
 func (c *Server) AcceptInboundConnection(conn net.Conn, config *ssh.ServerConfig) {
 	defer conn.Close()
+	if c.timeouts.Handshake > 0 {
+		conn.SetDeadline(time.Now().Add(c.timeouts.Handshake))
+	}
 	sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
 	if err != nil {
 		return
 	}
 	defer sconn.Close()
+	conn.SetDeadline(time.Time{})
+	c.loggedIn(sconn)
+	act := newActivity()
+	done := make(chan struct{})
+	defer close(done)
+	go c.watch(sconn, act, done)
+	if c.metrics != nil {
+		c.metrics.sessions.Inc()
+		defer c.metrics.sessions.Dec()
+	}
+	ctx, span := c.startSession(sconn)
+	defer span.End()
 	go ssh.DiscardRequests(reqs)
+	greeted := false
 	for newChannel := range chans {
 		if newChannel.ChannelType() != "session" {
 			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
@@ -203,12 +446,16 @@ func (c *Server) AcceptInboundConnection(conn net.Conn, config *ssh.ServerConfig
 		if sconn.Permissions.Extensions["uuid"] == "" {
 			continue
 		}
-		handlers, err := c.createHandler(sconn)
+		handlers, err := c.createHandler(ctx, sconn, act)
 		if err != nil {
 			newChannel.Reject(ssh.ConnectionFailed, err.Error())
 			channel.Close()
 			return
 		}
+		if !greeted {
+			greeted = true
+			c.sendLoginMessage(channel, sconn)
+		}
 		server := sftp.NewRequestServer(channel, handlers)
 		if err := server.Serve(); err == io.EOF {
 			server.Close()
@@ -219,23 +466,30 @@ func (c *Server) AcceptInboundConnection(conn net.Conn, config *ssh.ServerConfig
 // Creates a new SFTP handler for a given server. The directory argument should
 // be the base directory for a server. All actions done on the server will be
 // relative to that directory, and the user will not be able to escape out of it.
-func (c *Server) createHandler(sconn *ssh.ServerConn) (sftp.Handlers, error) {
+func (c *Server) createHandler(ctx context.Context, sconn *ssh.ServerConn, act *activity) (sftp.Handlers, error) {
 	fst, err := c.getUserFilesystem(sconn, c.basePath)
 	if err != nil {
 		return sftp.Handlers{}, err
 	}
-	if c.notify {
-		fst = NewFS(fst, c.notificationCallback)
-	}
 	ext := sconn.Permissions.Extensions
-	ctx := make(map[string]string)
+	if ext["read_only"] == "true" {
+		fst.SetReadOnly(true)
+	}
+	wrapper := &FS{fs: fst, callback: c.publish, server: c, session: ctx, activity: act}
+	upload, _ := strconv.ParseInt(ext["upload_rate"], 10, 64)
+	download, _ := strconv.ParseInt(ext["download_rate"], 10, 64)
+	if upload > 0 || download > 0 {
+		wrapper.userLimit = c.userBandwidth(ext["user"], upload, download)
+	}
+	fst = wrapper
+	values := make(map[string]string)
 	for key, val := range ext {
-		if !slices.Contains([]string{"filesystem", "default_fs", "server_version", "login_at", "uuid"}, key) {
-			ctx[key] = val
+		if !slices.Contains([]string{"filesystem", "default_fs", "server_version", "login_at", "uuid", "upload_rate", "download_rate", "read_only", "fs_type"}, key) {
+			values[key] = val
 		}
 	}
 	fst.SetConn(sconn)
-	fst.SetContext(ctx)
+	fst.SetContext(values)
 	fst.SetID(ext["uuid"])
 	return sftp.Handlers{FileGet: fst, FilePut: fst, FileCmd: fst, FileList: fst}, nil
 }
@@ -244,95 +498,51 @@ func (c *Server) getSSHPath(file string) string {
 	return path.Join(c.basePath, c.sshPath, file)
 }
 
-func (c *Server) setupSSH() (*ssh.ServerConfig, error) {
+func (c *Server) setupSSH(l Listener) (*ssh.ServerConfig, error) {
 	config := &ssh.ServerConfig{
-		NoClientAuth:     false,
-		MaxAuthTries:     6,
-		PasswordCallback: c.Validate,
+		NoClientAuth:   false,
+		MaxAuthTries:   6,
+		BannerCallback: c.banner(l.Banner),
 	}
-	if _, err := os.Stat(c.getSSHPath(c.privateKey)); os.IsNotExist(err) {
-		if err := c.generatePrivateKey(); err != nil {
-			return nil, err
-		}
-	} else if err != nil {
-		return nil, err
+	if l.allows(AuthPassword) {
+		config.PasswordCallback = withListener(l.Name, c.Validate)
 	}
-	
-	privateBytes, err := os.ReadFile(c.getSSHPath(c.privateKey))
-	if err != nil {
-		return nil, err
+	if l.allows(AuthCertificate) && len(c.userCAs) > 0 {
+		config.PublicKeyCallback = withListener(l.Name, c.ValidateCertificate)
 	}
-	
-	private, err := ssh.ParsePrivateKey(privateBytes)
-	if err != nil {
-		return nil, err
+	if config.PasswordCallback == nil && config.PublicKeyCallback == nil {
+		return nil, errors.New("no authentication method available")
 	}
-	
-	// Add our private key to the server configuration.
-	config.AddHostKey(private)
-	/*err = c.generatePublicKey(privateBytes)
+	signers, err := c.loadHostKeys(l.HostKeyTypes)
 	if err != nil {
 		return nil, err
-	}*/
-	return config, nil
-}
-
-// Generates a private key that will be used by the SFTP server.
-func (c *Server) generatePrivateKey() error {
-	key, err := rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
-		return err
 	}
-	
-	if err := os.MkdirAll(path.Join(c.basePath, c.sshPath), 0755); err != nil {
-		return err
-	}
-	
-	o, err := os.OpenFile(c.getSSHPath(c.privateKey), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		return err
-	}
-	defer o.Close()
-	
-	pkey := &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(key),
-	}
-	return pem.Encode(o, pkey)
-}
-
-// GetPublicKey extracts the public key from an ssh.Signer (typically a private key)
-func (c *Server) generatePublicKey(privKey []byte) error {
-	_, err := os.Stat(c.getSSHPath(c.publicKey))
-	if err != nil {
-		if !os.IsNotExist(err) {
-			return err
+	for _, file := range l.HostKeyFiles {
+		signer, err := c.loadHostKeyFile(file)
+		if err != nil {
+			return nil, err
 		}
+		signers = append(signers, signer)
 	}
-	
-	block, _ := pem.Decode(privKey)
-	if block == nil || block.Type != "RSA PRIVATE KEY" {
-		return errors.New("failed to decode PEM block containing public key")
-	}
-	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
-	if err != nil {
-		return err
-	}
-	
-	// publicKeyDer := x509.MarshalPKCS1PublicKey(&key.PublicKey)
-	publicKeyDer, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
-	if err != nil {
-		return err
-	}
-	o, err := os.OpenFile(c.getSSHPath(c.publicKey), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		return err
+	if l.CryptoPolicy != nil {
+		policy, err := l.CryptoPolicy.Resolve()
+		if err != nil {
+			return nil, err
+		}
+		if signers, err = policy.apply(config, signers); err != nil {
+			return nil, err
+		}
+		c.logger.Info("Crypto policy applied",
+			"listener", l.Name,
+			"preset", policy.Preset,
+			"ciphers", policy.Ciphers,
+			"key_exchanges", policy.KeyExchanges,
+			"macs", policy.MACs,
+			"host_key_algorithms", policy.HostKeyAlgorithms,
+		)
 	}
-	defer o.Close()
-	pubKeyBlock := &pem.Block{
-		Type:    "PUBLIC KEY",
-		Headers: nil,
-		Bytes:   publicKeyDer,
+	for _, signer := range signers {
+		config.AddHostKey(signer)
 	}
-	return pem.Encode(o, pubKeyBlock)
+	return config, nil
 }
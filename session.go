@@ -0,0 +1,128 @@
+package ftpserver
+
+import (
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Timeouts ... Bounds the lifetime of connections so that dead or idle clients do not hold on
+// to their connection and goroutines forever. A zero duration disables the matching check.
+type Timeouts struct {
+	// Handshake bounds the SSH handshake, authentication included.
+	Handshake time.Duration `json:"handshake"`
+	// Idle closes sessions without any SFTP activity for this long.
+	Idle time.Duration `json:"idle"`
+	// MaxSession closes sessions once they have been open for this long.
+	MaxSession time.Duration `json:"max_session"`
+	// KeepaliveInterval is how often a keepalive@openssh.com request is sent to the client.
+	// Clients failing to answer KeepaliveMaxMissed requests in a row, three by default, are
+	// disconnected.
+	KeepaliveInterval  time.Duration `json:"keepalive_interval"`
+	KeepaliveMaxMissed int           `json:"keepalive_max_missed"`
+}
+
+// activity ... Records when a session last did something.
+type activity struct {
+	last atomic.Int64
+}
+
+func newActivity() *activity {
+	a := &activity{}
+	a.touch()
+	return a
+}
+
+func (a *activity) touch() {
+	if a != nil {
+		a.last.Store(time.Now().UnixNano())
+	}
+}
+
+func (a *activity) idle() time.Duration {
+	return time.Since(time.Unix(0, a.last.Load()))
+}
+
+// watch enforces the idle and session timeouts and sends keepalives until done is closed,
+// closing the connection when the client is idle, over its time or unresponsive.
+func (c *Server) watch(sconn *ssh.ServerConn, act *activity, done <-chan struct{}) {
+	t := c.timeouts
+	shortest := minPositive(t.Idle, t.MaxSession, t.KeepaliveInterval)
+	if shortest <= 0 {
+		return
+	}
+	// Check a few times per period so that timeouts are enforced close to their deadline.
+	tick := min(max(shortest/4, 10*time.Millisecond), 30*time.Second)
+	maxMissed := t.KeepaliveMaxMissed
+	if maxMissed <= 0 {
+		maxMissed = 3
+	}
+
+	started := time.Now()
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	var (
+		pending    chan struct{}
+		missed     int
+		lastPinged time.Time
+	)
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			reason := ""
+			switch {
+			case t.MaxSession > 0 && now.Sub(started) >= t.MaxSession:
+				reason = "maximum session duration reached"
+			case t.Idle > 0 && act.idle() >= t.Idle:
+				reason = "idle timeout"
+			}
+			if reason == "" && t.KeepaliveInterval > 0 && now.Sub(lastPinged) >= t.KeepaliveInterval {
+				lastPinged = now
+				if pending != nil {
+					select {
+					case <-pending:
+						missed = 0
+					default:
+						missed++
+					}
+				}
+				if missed >= maxMissed {
+					reason = "keepalive timeout"
+				} else if pending == nil || missed == 0 {
+					pending = make(chan struct{})
+					go keepalive(sconn, pending)
+				}
+			}
+			if reason != "" {
+				c.logger.Warn("closing session",
+					"user", sconn.User(),
+					"remote_addr", sconn.RemoteAddr().String(),
+					"reason", reason,
+				)
+				sconn.Close()
+				return
+			}
+		}
+	}
+}
+
+// keepalive sends a keepalive request, closing answered once the client has replied. Any
+// reply, even a refusal, shows that the client is alive.
+func keepalive(sconn *ssh.ServerConn, answered chan<- struct{}) {
+	if _, _, err := sconn.SendRequest("keepalive@openssh.com", true, nil); err == nil {
+		close(answered)
+	}
+}
+
+func minPositive(durations ...time.Duration) time.Duration {
+	var m time.Duration
+	for _, d := range durations {
+		if d > 0 && (m == 0 || d < m) {
+			m = d
+		}
+	}
+	return m
+}
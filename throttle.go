@@ -0,0 +1,138 @@
+package ftpserver
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+// burst ... The burst of a bandwidth limiter, the size of the largest SFTP data packet. It is
+// kept that small whatever the limit, so that a transfer can never get ahead of the limit by
+// more than a single packet, small transfers included.
+const burst = 32 << 10
+
+// bandwidth ... Token buckets limiting uploads and downloads, in bytes per second. A nil
+// limiter does not limit anything.
+type bandwidth struct {
+	upload   *rate.Limiter
+	download *rate.Limiter
+}
+
+func newBandwidth(upload, download int64) *bandwidth {
+	return &bandwidth{upload: newLimiter(upload), download: newLimiter(download)}
+}
+
+func newLimiter(bytesPerSecond int64) *rate.Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), burst)
+}
+
+// userBandwidth returns the limiters shared by every session of the user, adjusting them when
+// the limits of the user have changed since they were created.
+func (c *Server) userBandwidth(user string, upload, download int64) *bandwidth {
+	c.bandwidthMu.Lock()
+	defer c.bandwidthMu.Unlock()
+	if c.userLimits == nil {
+		c.userLimits = make(map[string]*bandwidth)
+	}
+	b, ok := c.userLimits[user]
+	if !ok || limitOf(b.upload) != upload || limitOf(b.download) != download {
+		b = newBandwidth(upload, download)
+		c.userLimits[user] = b
+	}
+	return b
+}
+
+func limitOf(l *rate.Limiter) int64 {
+	if l == nil {
+		return 0
+	}
+	return int64(l.Limit())
+}
+
+// waitN blocks until every limiter allows n bytes, or the context is done.
+func waitN(ctx context.Context, limiters []*rate.Limiter, n int) error {
+	for _, l := range limiters {
+		for remaining := n; remaining > 0; {
+			chunk := min(remaining, l.Burst())
+			if err := l.WaitN(ctx, chunk); err != nil {
+				return err
+			}
+			remaining -= chunk
+		}
+	}
+	return nil
+}
+
+// throttledReader ... Delays reads of a download to the rate allowed by its limiters.
+type throttledReader struct {
+	reader   io.ReaderAt
+	ctx      context.Context
+	limiters []*rate.Limiter
+}
+
+func (r *throttledReader) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.reader.ReadAt(p, off)
+	if n > 0 {
+		if werr := waitN(r.ctx, r.limiters, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// throttledWriter ... Delays writes of an upload to the rate allowed by its limiters.
+type throttledWriter struct {
+	writer   io.WriterAt
+	ctx      context.Context
+	limiters []*rate.Limiter
+}
+
+func (w *throttledWriter) WriteAt(p []byte, off int64) (int, error) {
+	if err := waitN(w.ctx, w.limiters, len(p)); err != nil {
+		return 0, err
+	}
+	return w.writer.WriteAt(p, off)
+}
+
+// limiters returns the non-nil limiters picked from each bandwidth.
+func limiters(pick func(*bandwidth) *rate.Limiter, bandwidths ...*bandwidth) []*rate.Limiter {
+	var out []*rate.Limiter
+	for _, b := range bandwidths {
+		if b == nil {
+			continue
+		}
+		if l := pick(b); l != nil {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// throttleReader limits a download to the bandwidth of the server and of the session user.
+func (f *FS) throttleReader(ctx context.Context, r io.ReaderAt) io.ReaderAt {
+	ls := limiters(func(b *bandwidth) *rate.Limiter { return b.download }, f.bandwidth()...)
+	if len(ls) == 0 {
+		return r
+	}
+	return &throttledReader{reader: r, ctx: ctx, limiters: ls}
+}
+
+// throttleWriter limits an upload to the bandwidth of the server and of the session user.
+func (f *FS) throttleWriter(ctx context.Context, w io.WriterAt) io.WriterAt {
+	ls := limiters(func(b *bandwidth) *rate.Limiter { return b.upload }, f.bandwidth()...)
+	if len(ls) == 0 {
+		return w
+	}
+	return &throttledWriter{writer: w, ctx: ctx, limiters: ls}
+}
+
+func (f *FS) bandwidth() []*bandwidth {
+	if f.server == nil {
+		return []*bandwidth{f.userLimit}
+	}
+	return []*bandwidth{f.server.globalLimit, f.userLimit}
+}
@@ -0,0 +1,64 @@
+package ftpserver
+
+import (
+	"context"
+	"errors"
+
+	"github.com/pkg/sftp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"golang.org/x/crypto/ssh"
+)
+
+const tracerName = "github.com/oarkflow/ftp-server"
+
+// noopTracer is used by servers without a tracer provider, and by filesystems created with NewFS.
+var noopTracer = noop.NewTracerProvider().Tracer(tracerName)
+
+// startSession starts the span covering an authenticated SSH session, the parent of the spans
+// of its SFTP requests.
+func (c *Server) startSession(sconn *ssh.ServerConn) (context.Context, trace.Span) {
+	ext := sconn.Permissions.Extensions
+	return c.tracer.Start(context.Background(), "sftp.session",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("enduser.id", sconn.User()),
+			attribute.String("client.address", sconn.RemoteAddr().String()),
+			attribute.String("sftp.client_version", string(sconn.ClientVersion())),
+			attribute.String("sftp.session_id", ext["session_id"]),
+		),
+	)
+}
+
+// startSpan starts the span of an SFTP request, and returns a copy of the request carrying it
+// in its context so that backend calls are recorded as its children.
+func (f *FS) startSpan(request *sftp.Request) (*sftp.Request, trace.Span) {
+	tracer := noopTracer
+	ctx := request.Context()
+	if f.server != nil {
+		tracer = f.server.tracer
+	}
+	if f.session != nil {
+		ctx = trace.ContextWithSpan(ctx, trace.SpanFromContext(f.session))
+	}
+	attrs := []attribute.KeyValue{
+		attribute.String("sftp.method", request.Method),
+		attribute.String("sftp.path", request.Filepath),
+	}
+	if request.Target != "" {
+		attrs = append(attrs, attribute.String("sftp.target", request.Target))
+	}
+	ctx, span := tracer.Start(ctx, "sftp."+request.Method, trace.WithAttributes(attrs...))
+	return request.WithContext(ctx), span
+}
+
+// endSpan ends the span, marking it as failed when err is a real error.
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, sftp.ErrSshFxOk) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,90 @@
+// Package tracing builds OpenTelemetry tracer providers exporting the spans of the server over
+// OTLP, or to stdout for local testing.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+const (
+	// ExporterOTLP ... Exports spans over OTLP/HTTP.
+	ExporterOTLP = "otlp"
+	// ExporterStdout ... Writes spans as JSON, to stdout unless a writer is given.
+	ExporterStdout = "stdout"
+)
+
+// Config ... Configures the tracer provider.
+type Config struct {
+	// Exporter is ExporterOTLP or ExporterStdout.
+	Exporter string `json:"exporter"`
+	// Endpoint is the host:port of the OTLP/HTTP collector. When empty the standard
+	// OTEL_EXPORTER_OTLP_* environment variables apply, defaulting to localhost:4318.
+	Endpoint string            `json:"endpoint"`
+	Insecure bool              `json:"insecure"`
+	Headers  map[string]string `json:"headers"`
+	// ServiceName defaults to "ftp-server".
+	ServiceName string `json:"service_name"`
+	// SampleRatio is the fraction of sessions traced, every session is traced when zero.
+	SampleRatio float64 `json:"sample_ratio"`
+	// Writer receives the spans of the stdout exporter.
+	Writer io.Writer `json:"-"`
+}
+
+// NewProvider creates a tracer provider exporting spans in batches as configured. It must be
+// shut down to flush the spans still buffered.
+func NewProvider(ctx context.Context, config Config) (*sdktrace.TracerProvider, error) {
+	exporter, err := newExporter(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	name := config.ServiceName
+	if name == "" {
+		name = "ftp-server"
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(name)))
+	if err != nil {
+		return nil, err
+	}
+	sampler := sdktrace.AlwaysSample()
+	if config.SampleRatio > 0 && config.SampleRatio < 1 {
+		sampler = sdktrace.TraceIDRatioBased(config.SampleRatio)
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+	), nil
+}
+
+func newExporter(ctx context.Context, config Config) (sdktrace.SpanExporter, error) {
+	switch config.Exporter {
+	case ExporterOTLP:
+		var opts []otlptracehttp.Option
+		if config.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(config.Endpoint))
+		}
+		if config.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		if len(config.Headers) > 0 {
+			opts = append(opts, otlptracehttp.WithHeaders(config.Headers))
+		}
+		return otlptracehttp.New(ctx, opts...)
+	case ExporterStdout:
+		w := config.Writer
+		if w == nil {
+			w = os.Stdout
+		}
+		return stdouttrace.New(stdouttrace.WithWriter(w))
+	}
+	return nil, fmt.Errorf("tracing: unknown exporter %q", config.Exporter)
+}
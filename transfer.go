@@ -0,0 +1,322 @@
+package ftpserver
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/sftp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxPendingChecksum ... The amount of data that is held back while waiting for an earlier
+// chunk of a transfer to arrive, before giving up on computing its checksum.
+const maxPendingChecksum = 8 << 20
+
+// transfer ... Tracks an open upload or download handle so that a completion event carrying
+// the number of bytes, the duration and the checksum of the content can be emitted once the
+// client closes it.
+type transfer struct {
+	fs       *FS
+	request  *sftp.Request
+	event    string
+	handle   any
+	started  time.Time
+	bytes    atomic.Int64
+	checksum *checksum
+	// coverage tracks the parts of a download that were read, to tell whether it completed.
+	coverage coverage
+	mu       sync.Mutex
+	err      error
+	closed   bool
+	// span is the span of the request, it covers the whole transfer.
+	span trace.Span
+}
+
+func (f *FS) newTransfer(event string, request *sftp.Request, handle any, span trace.Span) *transfer {
+	return &transfer{
+		fs:       f,
+		request:  request,
+		event:    event,
+		handle:   handle,
+		started:  time.Now(),
+		checksum: newChecksum(event == EventUploadCompleted),
+		span:     span,
+	}
+}
+
+func (t *transfer) add(p []byte, off int64) {
+	t.fs.activity.touch()
+	t.bytes.Add(int64(len(p)))
+	t.checksum.add(p, off)
+}
+
+// TransferError records the error that interrupted the transfer and passes it on to the
+// underlying handle.
+func (t *transfer) TransferError(err error) {
+	t.mu.Lock()
+	t.err = err
+	t.mu.Unlock()
+	if te, ok := t.handle.(sftp.TransferError); ok {
+		te.TransferError(err)
+	}
+}
+
+// Close closes the underlying handle and emits the completion event.
+func (t *transfer) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+
+	// The size of the file is asked from the handle, which has to be open still.
+	if t.event == EventDownloadCompleted && !t.downloaded() {
+		t.event = EventDownloadAborted
+	}
+	var err error
+	if c, ok := t.handle.(io.Closer); ok {
+		err = c.Close()
+	}
+	result := err
+	if t.err != nil {
+		result = t.err
+	}
+	t.fs.NotifyTransfer(t, result)
+	t.span.SetAttributes(attribute.Int64("sftp.bytes", t.bytes.Load()))
+	endSpan(t.span, result)
+	return err
+}
+
+// downloaded reports whether every byte of the file, up to its size, was read. The size is
+// known from reading past the end of the file or, when the client stopped short of that, from
+// the open handle. Downloads of handles that cannot tell their size count as aborted then.
+func (t *transfer) downloaded() bool {
+	size, ok := t.coverage.eof()
+	if !ok {
+		s, ok := t.handle.(stater)
+		if !ok {
+			return false
+		}
+		info, err := s.Stat()
+		if err != nil {
+			return false
+		}
+		size = info.Size()
+	}
+	return t.coverage.read() >= size
+}
+
+// stater ... Implemented by the handles that describe the file they are open on, as os.File
+// does.
+type stater interface {
+	Stat() (os.FileInfo, error)
+}
+
+// readTransfer ... The handle of a download.
+type readTransfer struct {
+	*transfer
+	reader io.ReaderAt
+}
+
+func (t *readTransfer) ReadAt(p []byte, off int64) (int, error) {
+	n, err := t.reader.ReadAt(p, off)
+	if n > 0 {
+		t.add(p[:n], off)
+	}
+	t.coverage.add(off, off+int64(n), errors.Is(err, io.EOF))
+	return n, err
+}
+
+// writeTransfer ... The handle of an upload.
+type writeTransfer struct {
+	*transfer
+	writer io.WriterAt
+}
+
+func (t *writeTransfer) WriteAt(p []byte, off int64) (int, error) {
+	n, err := t.writer.WriteAt(p, off)
+	if n > 0 {
+		t.add(p[:n], off)
+	}
+	return n, err
+}
+
+// coverage ... The ranges of a file that were read, merged as they join up.
+type coverage struct {
+	mu      sync.Mutex
+	extents []extent
+	size    int64
+	sawEOF  bool
+}
+
+// extent ... A range of a file, from start up to end.
+type extent struct {
+	start, end int64
+}
+
+// add records that the range from start up to end was read, reaching the end of the file when
+// eof is set. Pipelined reads may hit the end further on than where the file ends.
+func (c *coverage) add(start, end int64, eof bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if eof && (!c.sawEOF || end < c.size) {
+		c.size = end
+		c.sawEOF = true
+	}
+	if end <= start {
+		return
+	}
+	c.extents = append(c.extents, extent{start, end})
+	slices.SortFunc(c.extents, func(a, b extent) int { return cmp.Compare(a.start, b.start) })
+	merged := c.extents[:1]
+	for _, e := range c.extents[1:] {
+		last := &merged[len(merged)-1]
+		if e.start > last.end {
+			merged = append(merged, e)
+			continue
+		}
+		last.end = max(last.end, e.end)
+	}
+	c.extents = merged
+}
+
+// read returns the number of bytes read from the start of the file without a gap.
+func (c *coverage) read() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.extents) == 0 || c.extents[0].start > 0 {
+		return 0
+	}
+	return c.extents[0].end
+}
+
+// eof returns the size of the file, if a read reached its end.
+func (c *coverage) eof() (int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size, c.sawEOF
+}
+
+// checksum ... A SHA-256 computed over the content of a transfer while it streams through.
+// SFTP clients pipeline their requests, so chunks that arrive ahead of the current offset
+// are held back until the gap before them is filled.
+type checksum struct {
+	// overwrites is set for uploads, where a chunk arriving for a range that was already
+	// hashed replaces content rather than repeating it.
+	overwrites bool
+	mu         sync.Mutex
+	hash       hash.Hash
+	offset     int64
+	pending    map[int64][]byte
+	buffered   int
+	broken     bool
+}
+
+func newChecksum(overwrites bool) *checksum {
+	return &checksum{overwrites: overwrites, hash: sha256.New(), pending: make(map[int64][]byte)}
+}
+
+func (c *checksum) add(p []byte, off int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.broken {
+		return
+	}
+
+	if off > c.offset {
+		if held, exists := c.pending[off]; exists {
+			// A second upload to the same range replaces the first, a download yields the
+			// same content again.
+			if c.overwrites {
+				c.abandon()
+				return
+			}
+			if len(held) >= len(p) {
+				return
+			}
+			c.buffered -= len(held)
+		}
+		if c.buffered+len(p) > maxPendingChecksum {
+			c.abandon()
+			return
+		}
+		c.pending[off] = append([]byte(nil), p...)
+		c.buffered += len(p)
+		return
+	}
+
+	c.consume(p, off)
+	c.drain()
+}
+
+// consume hashes the part of p, found at an offset the checksum has already reached, that
+// lies beyond it.
+func (c *checksum) consume(p []byte, off int64) {
+	if off < c.offset {
+		// Reading the same range twice yields the same content, so only the part that
+		// has not been hashed yet matters.
+		if c.overwrites {
+			c.abandon()
+			return
+		}
+		if off+int64(len(p)) <= c.offset {
+			return
+		}
+		p = p[c.offset-off:]
+	}
+	c.write(p)
+}
+
+// drain hashes the chunks held back that the offset has caught up with, including those that
+// overlap content hashed already.
+func (c *checksum) drain() {
+	for !c.broken {
+		caught := false
+		for off, p := range c.pending {
+			if off > c.offset {
+				continue
+			}
+			delete(c.pending, off)
+			c.buffered -= len(p)
+			c.consume(p, off)
+			caught = true
+			break
+		}
+		if !caught {
+			return
+		}
+	}
+}
+
+func (c *checksum) write(p []byte) {
+	c.hash.Write(p)
+	c.offset += int64(len(p))
+}
+
+func (c *checksum) abandon() {
+	c.broken = true
+	c.pending = nil
+	c.buffered = 0
+}
+
+// sum returns the hex encoded checksum, or an empty string if parts of the content were
+// never seen in order.
+func (c *checksum) sum() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.broken || len(c.pending) > 0 {
+		return ""
+	}
+	return hex.EncodeToString(c.hash.Sum(nil))
+}
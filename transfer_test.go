@@ -0,0 +1,209 @@
+package ftpserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/oarkflow/ftp-server/fs"
+	"github.com/oarkflow/ftp-server/fs/afos"
+	"github.com/oarkflow/ftp-server/fs/fstest"
+	"github.com/oarkflow/ftp-server/log/oarklog"
+)
+
+func TestDownloaded(t *testing.T) {
+	file, err := os.CreateTemp(t.TempDir(), "download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString("0123456789"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		handle any
+		// read is the range read, reaching the end of the file when eof is set.
+		read extent
+		eof  bool
+		want bool
+	}{
+		{name: "read up to the size", handle: file, read: extent{0, 10}, want: true},
+		{name: "read short of the size", handle: file, read: extent{0, 9}},
+		{name: "read with a gap", handle: file, read: extent{1, 10}},
+		{name: "read to the end", handle: strings.NewReader("0123456789"), read: extent{0, 10}, eof: true, want: true},
+		{name: "handle without a size", handle: strings.NewReader("0123456789"), read: extent{0, 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transfer := &transfer{handle: tt.handle}
+			transfer.coverage.add(tt.read.start, tt.read.end, tt.eof)
+			if got := transfer.downloaded(); got != tt.want {
+				t.Fatalf("downloaded() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestDownloadReadContentOnly downloads a file as a user allowed to read its content, but not
+// to list or stat it.
+func TestDownloadReadContentOnly(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "data"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "data", "file.txt"), []byte(fstest.Content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	backend := afos.New(dir, afos.WithPermissions([]string{fs.ReadContent}))
+	backend.SetLogger(oarklog.Default())
+	var mu sync.Mutex
+	var events []string
+	fsys := NewFS(backend, func(n Notification) error {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, n.Event)
+		return nil
+	})
+
+	r, err := fsys.Fileread(fstest.Request("Get", "/file.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The client stops once it has the whole file, without reading past its end.
+	buf := make([]byte, len(fstest.Content))
+	if n, err := r.ReadAt(buf, 0); n != len(buf) || err != nil {
+		t.Fatalf("read %d bytes, %v", n, err)
+	}
+	if err := r.(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, event := range events {
+		if event == EventDownloadAborted {
+			t.Fatalf("complete download reported as aborted: %v", events)
+		}
+		if event == EventDownloadCompleted {
+			return
+		}
+	}
+	t.Fatalf("no %s notification among %v", EventDownloadCompleted, events)
+}
+
+func TestChecksum(t *testing.T) {
+	const content = "0123456789"
+	sum := sha256.Sum256([]byte(content))
+	complete := hex.EncodeToString(sum[:])
+
+	// chunk ... Part of content, from start up to end, handed over at once.
+	type chunk struct {
+		start, end int
+	}
+	tests := []struct {
+		name       string
+		overwrites bool
+		chunks     []chunk
+		// complete is set when the checksum must cover content, it is abandoned otherwise.
+		complete bool
+	}{
+		{name: "in order", chunks: []chunk{{0, 4}, {4, 8}, {8, 10}}, complete: true},
+		{name: "pipelined download", chunks: []chunk{{8, 10}, {4, 8}, {0, 4}}, complete: true},
+		{name: "pipelined upload", overwrites: true, chunks: []chunk{{4, 8}, {8, 10}, {0, 4}}, complete: true},
+		{name: "download read again", chunks: []chunk{{0, 4}, {0, 4}, {2, 8}, {0, 10}}, complete: true},
+		{name: "download read again ahead", chunks: []chunk{{4, 6}, {4, 8}, {4, 6}, {8, 10}, {0, 5}}, complete: true},
+		{name: "upload overwrite", overwrites: true, chunks: []chunk{{0, 4}, {0, 4}, {4, 10}}},
+		{name: "upload overwrite overlapping", overwrites: true, chunks: []chunk{{0, 6}, {4, 10}}},
+		{name: "upload overwrite ahead", overwrites: true, chunks: []chunk{{4, 8}, {4, 8}, {0, 4}, {8, 10}}},
+		{name: "gap never filled", chunks: []chunk{{0, 4}, {6, 10}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newChecksum(tt.overwrites)
+			for _, ch := range tt.chunks {
+				c.add([]byte(content[ch.start:ch.end]), int64(ch.start))
+			}
+			want := ""
+			if tt.complete {
+				want = complete
+			}
+			if got := c.sum(); got != want {
+				t.Fatalf("sum() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// TestChecksumPendingOverflow holds back more content than maxPendingChecksum allows, waiting
+// for the first byte.
+func TestChecksumPendingOverflow(t *testing.T) {
+	for _, overwrites := range []bool{false, true} {
+		c := newChecksum(overwrites)
+		chunk := bytes.Repeat([]byte("x"), maxPendingChecksum/2)
+		c.add(chunk, 1)
+		c.add(chunk, int64(1+len(chunk)))
+		if c.buffered != maxPendingChecksum {
+			t.Fatalf("holding back %d bytes, want %d", c.buffered, maxPendingChecksum)
+		}
+		c.add([]byte("x"), int64(1+2*len(chunk)))
+		c.add([]byte("x"), 0)
+		if got := c.sum(); got != "" || c.buffered != 0 {
+			t.Fatalf("sum() = %q holding back %d bytes after the overflow, want the checksum abandoned", got, c.buffered)
+		}
+	}
+}
+
+func TestCoverage(t *testing.T) {
+	// read ... A read of the range from start up to end, reaching the end of the file when
+	// eof is set.
+	type read struct {
+		start, end int64
+		eof        bool
+	}
+	tests := []struct {
+		name     string
+		reads    []read
+		wantRead int64
+		// wantSize is the size of the file, -1 when no read reached its end.
+		wantSize int64
+	}{
+		{name: "nothing read", wantSize: -1},
+		{name: "in order", reads: []read{{0, 4, false}, {4, 10, false}}, wantRead: 10, wantSize: -1},
+		{name: "pipelined", reads: []read{{8, 10, false}, {0, 4, false}, {4, 8, false}}, wantRead: 10, wantSize: -1},
+		{name: "read again", reads: []read{{0, 6, false}, {2, 4, false}, {0, 10, false}}, wantRead: 10, wantSize: -1},
+		{name: "gap", reads: []read{{0, 4, false}, {6, 10, false}}, wantRead: 4, wantSize: -1},
+		{name: "start missing", reads: []read{{2, 10, false}}, wantSize: -1},
+		{name: "empty file", reads: []read{{0, 0, true}}, wantSize: 0},
+		{name: "short read at the end", reads: []read{{0, 4, false}, {4, 10, true}}, wantRead: 10, wantSize: 10},
+		// Pipelined reads past the end of the file hit it where they start, after a short
+		// read already found where the file ends.
+		{name: "eof beyond the end", reads: []read{{0, 4, false}, {8, 10, true}, {12, 12, true}, {4, 8, false}}, wantRead: 10, wantSize: 10},
+		{name: "eof beyond the end first", reads: []read{{12, 12, true}, {8, 10, true}, {0, 8, false}}, wantRead: 10, wantSize: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c coverage
+			for _, r := range tt.reads {
+				c.add(r.start, r.end, r.eof)
+			}
+			if got := c.read(); got != tt.wantRead {
+				t.Errorf("read() = %d, want %d", got, tt.wantRead)
+			}
+			size, ok := c.eof()
+			if !ok {
+				size = -1
+			}
+			if size != tt.wantSize {
+				t.Errorf("eof() = %d, %v, want %d", size, ok, tt.wantSize)
+			}
+		})
+	}
+}
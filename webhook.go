@@ -0,0 +1,122 @@
+package ftpserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"slices"
+	"time"
+)
+
+// WebhookConfig ... Configures a notification sink that POSTs every notification as JSON to a
+// set of URLs.
+type WebhookConfig struct {
+	URLs []string `json:"urls"`
+	// Events, Users and Paths restrict which notifications are sent, an empty list matches
+	// everything. Paths are path.Match patterns checked against the subject and the target.
+	Events []string `json:"events"`
+	Users  []string `json:"users"`
+	Paths  []string `json:"paths"`
+	// Secret, when set, is used to sign the body with HMAC-SHA256. The signature is sent in
+	// the X-Signature-256 header as "sha256=<hex>".
+	Secret  string            `json:"secret"`
+	Headers map[string]string `json:"headers"`
+	// Timeout bounds each request, it defaults to ten seconds.
+	Timeout time.Duration `json:"timeout"`
+}
+
+// Matches reports whether the notification passes the event, user and path filters.
+func (c WebhookConfig) Matches(n Notification) bool {
+	if len(c.Events) > 0 && !slices.Contains(c.Events, n.Event) {
+		return false
+	}
+	if len(c.Users) > 0 && !slices.Contains(c.Users, n.User) {
+		return false
+	}
+	if len(c.Paths) == 0 {
+		return true
+	}
+	for _, pattern := range c.Paths {
+		for _, p := range []string{n.Subject, n.Target} {
+			if p == "" {
+				continue
+			}
+			if matched, _ := path.Match(pattern, p); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NewWebhookSink returns a NotificationHandler posting notifications to the configured URLs.
+// It returns an error when any of the URLs fails to accept the notification, so it is best
+// used behind a Dispatcher which retries the delivery.
+//
+// Delivery is at least once: a retry posts the notification to every URL again, including
+// those that accepted it already. Each request carries an Idempotency-Key header, the same for
+// every attempt at delivering a notification, which receivers can use to discard duplicates.
+func NewWebhookSink(config WebhookConfig) NotificationHandler {
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	client := &http.Client{Timeout: config.Timeout}
+	return func(n Notification) error {
+		if !config.Matches(n) {
+			return nil
+		}
+		body, err := json.Marshal(n)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(body)
+		key := hex.EncodeToString(sum[:])
+		var errs []error
+		for _, url := range config.URLs {
+			if err := postWebhook(client, config, url, n.Event, key, body); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", url, err))
+			}
+		}
+		return errors.Join(errs...)
+	}
+}
+
+func postWebhook(client *http.Client, config WebhookConfig, url, event, key string, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event", event)
+	req.Header.Set("Idempotency-Key", key)
+	for key, val := range config.Headers {
+		req.Header.Set(key, val)
+	}
+	if config.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(config.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}